@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+	"github.com/fairwindsops/agones-allocator-client/pkg/kube"
+)
+
+var (
+	benchRequests    int
+	benchDuration    time.Duration
+	benchQPS         float64
+	benchConcurrency int
+	benchFree        bool
+	benchReportFile  string
+)
+
+func init() {
+	rootCmd.AddCommand(allocateBenchCmd)
+	allocateBenchCmd.PersistentFlags().IntVar(&benchRequests, "requests", 100, "The number of allocations to request. Zero means no limit, so --duration must be set.")
+	allocateBenchCmd.PersistentFlags().DurationVar(&benchDuration, "duration", 0, "How long to send requests for. Zero means until --requests have been sent.")
+	allocateBenchCmd.PersistentFlags().Float64Var(&benchQPS, "qps", 0, "The target number of requests per second. Zero sends requests back to back.")
+	allocateBenchCmd.PersistentFlags().IntVar(&benchConcurrency, "concurrency", 1, "The maximum number of requests in flight.")
+	allocateBenchCmd.PersistentFlags().BoolVar(&benchFree, "free", false, "Delete the allocated gameservers when the benchmark is done. Requires kubeconfig access to the cluster.")
+	allocateBenchCmd.PersistentFlags().StringVar(&benchReportFile, "report-file", "", "If set, the full results, including every latency, are written to this file as JSON.")
+}
+
+var allocateBenchCmd = &cobra.Command{
+	Use:     "allocate-bench",
+	Short:   "allocate-bench",
+	Long:    `Sends allocation requests at a target rate or concurrency, without connecting to the gameservers, and reports allocator latency and error codes.`,
+	PreRunE: argsValidator,
+	Run: func(cmd *cobra.Command, args []string) {
+		var kubeClient *kube.Client
+		if benchFree {
+			// Fail before allocating anything if the gameservers can't be cleaned up
+			var err error
+			kubeClient, err = kube.NewClient(kubeconfig, kubeContext)
+			if err != nil {
+				klog.Fatal(err)
+			}
+		}
+
+		allocatorClient, err := newAllocatorClient()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer closeRecorder(allocatorClient)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		report := allocator.NewBenchReport()
+		stop := handleInterrupts(cancel, "stopping the benchmark", func() {
+			report.Summary().Print(os.Stdout)
+		})
+		defer stop()
+
+		opts := allocator.BenchOptions{
+			Requests:    benchRequests,
+			Duration:    benchDuration,
+			QPS:         benchQPS,
+			Concurrency: benchConcurrency,
+		}
+		err = allocatorClient.RunBench(ctx, opts, report)
+		if err != nil {
+			closeRecorder(allocatorClient)
+			klog.Fatal(err)
+		}
+
+		summary := report.Summary()
+		summary.Print(os.Stdout)
+
+		// Free the gameservers before anything else can fail, so that none are left allocated
+		var cleanupErr error
+		if kubeClient != nil && len(summary.GameServers) > 0 {
+			klog.Infof("deleting %d allocated gameservers", len(summary.GameServers))
+			cleanupErr = kubeClient.DeleteGameServers(context.Background(), namespace, summary.GameServers)
+			if cleanupErr != nil {
+				klog.Errorf("could not delete the allocated gameservers - %s", cleanupErr.Error())
+			}
+		}
+
+		if benchReportFile != "" {
+			data, err := json.MarshalIndent(summary, "", "  ")
+			if err != nil {
+				closeRecorder(allocatorClient)
+				klog.Fatal(err)
+			}
+			err = ioutil.WriteFile(benchReportFile, data, 0644)
+			if err != nil {
+				closeRecorder(allocatorClient)
+				klog.Fatalf("could not write the report - %s", err.Error())
+			}
+		}
+		if cleanupErr != nil {
+			closeRecorder(allocatorClient)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/certs"
+)
+
+var certsHandshake bool
+
+func init() {
+	rootCmd.AddCommand(certsCmd)
+	certsCmd.AddCommand(certsCheckCmd)
+	certsCheckCmd.PersistentFlags().BoolVar(&certsHandshake, "handshake", false, "Also do a TLS handshake against each host in --hosts or --hosts-ping and check the server cert.")
+}
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "certs",
+	Long:  `Commands for inspecting the client certificates.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		if err != nil {
+			klog.Error(err)
+		}
+		os.Exit(1)
+	},
+}
+
+var certsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check",
+	Long:  `Checks that the client key, client cert and CA cert are valid and belong together, and optionally that each allocator host presents a trusted certificate for its name.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if certsHandshake && hosts == nil && pingServers == nil && !discoverHost {
+			return fmt.Errorf("you must set either hosts or hosts-ping, or use discover-allocator, to do a handshake")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		key, cert, ca, err := loadCredentials()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		report, err := certs.Check(key, cert, ca)
+		if err != nil {
+			klog.Fatal(err)
+		}
+
+		if certsHandshake {
+			hostList, err := allocatorHosts()
+			if err != nil {
+				klog.Fatal(err)
+			}
+			for host := range pingServers {
+				hostList = append(hostList, host)
+			}
+
+			clientCert, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				// Already reported as a problem
+				klog.Errorf("skipping the handshake - %s", err.Error())
+			} else {
+				var roots *x509.CertPool
+				if len(ca) > 0 {
+					roots = x509.NewCertPool()
+					roots.AppendCertsFromPEM(ca)
+				}
+				for _, host := range hostList {
+					report.Hosts = append(report.Hosts, certs.CheckHost(context.Background(), host, tlsServerName, clientCert, roots))
+				}
+			}
+		}
+
+		report.Print(os.Stdout)
+		if !report.OK() {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/kube"
+)
+
+// loadCredentials reads the client key, client cert and CA cert, either from the
+// files given by the flags or from kubernetes secrets
+func loadCredentials() (key []byte, cert []byte, ca []byte, err error) {
+	var kubeClient *kube.Client
+	if clientSecret != "" || caSecret != "" {
+		kubeClient, err = kube.NewClient(kubeconfig, kubeContext)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	ctx := context.Background()
+
+	if clientSecret != "" {
+		ref, err := kube.ParseSecretRef(clientSecret, namespace)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		key, cert, err = kubeClient.ClientCredentials(ctx, ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else if keyFile != "" || certFile != "" {
+		key, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cert, err = ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if caSecret != "" {
+		ref, err := kube.ParseSecretRef(caSecret, agonesNamespace)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ca, err = kubeClient.CACertificate(ctx, ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else if caCertFile != "" {
+		ca, err = ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return key, cert, ca, nil
+}
+
+// allocatorHosts returns the hosts from the flags, or discovers the allocator service if asked to
+func allocatorHosts() ([]string, error) {
+	if hosts != nil || pingServers != nil || !discoverHost {
+		return hosts, nil
+	}
+	kubeClient, err := kube.NewClient(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	address, err := kubeClient.AllocatorAddress(context.Background(), agonesNamespace, allocatorSvc)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("using discovered allocator %s", address)
+	return []string{address}, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+	"github.com/fairwindsops/agones-allocator-client/pkg/distributed"
+)
+
+var (
+	workerListen          string
+	workerName            string
+	coordinatorWorkers    []string
+	coordinatorReportFile string
+	workerToken           string
+)
+
+func init() {
+	hostname, _ := os.Hostname()
+
+	loadTestCmd.AddCommand(loadWorkerCmd)
+	loadWorkerCmd.PersistentFlags().StringVar(&workerListen, "listen", "127.0.0.1:8080", "The address to listen on for the coordinator. Use e.g. :8080 to accept coordinators on other machines.")
+	loadWorkerCmd.PersistentFlags().StringVar(&workerName, "name", hostname, "The name of this worker in the coordinator's report.")
+	loadWorkerCmd.PersistentFlags().StringVar(&workerToken, "worker-token", "", "The shared secret the coordinator must send. Required.")
+
+	loadTestCmd.AddCommand(loadCoordinatorCmd)
+	loadCoordinatorCmd.PersistentFlags().StringSliceVar(&coordinatorWorkers, "workers", nil, "The list of worker addresses, e.g. 10.0.0.1:8080,10.0.0.2:8080")
+	loadCoordinatorCmd.PersistentFlags().StringVar(&coordinatorReportFile, "report-file", "", "If set, the merged report is written to this file as JSON.")
+	loadCoordinatorCmd.PersistentFlags().StringVar(&workerToken, "worker-token", "", "The shared secret the workers were started with. Required.")
+}
+
+var loadWorkerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "worker",
+	Long:  `Runs slices of a load test handed out by a coordinator. The worker uses its own certificates and hosts to allocate.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if workerToken == "" {
+			return fmt.Errorf("you must set a shared secret with --worker-token")
+		}
+		return argsValidator(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		allocatorClient, err := newAllocatorClient()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer closeRecorder(allocatorClient)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := handleInterrupts(cancel, "stopping the worker", func() {})
+		defer stop()
+
+		worker := &distributed.Worker{
+			Name:  workerName,
+			Token: workerToken,
+			Run: func(ctx context.Context, opts allocator.LoadOptions) (allocator.LoadSummary, error) {
+				report := allocator.NewLoadReport()
+				err := allocatorClient.RunLoad(ctx, opts, report)
+				return report.Summary(), err
+			},
+		}
+		err = worker.ListenAndServe(ctx, workerListen)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	},
+}
+
+var loadCoordinatorCmd = &cobra.Command{
+	Use:   "coordinator",
+	Short: "coordinator",
+	Long:  `Splits a load test across a set of workers and merges their results into a single report.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(coordinatorWorkers) < 1 {
+			return fmt.Errorf("you must pass at least one worker with --workers")
+		}
+		if workerToken == "" {
+			return fmt.Errorf("you must set the workers' shared secret with --worker-token")
+		}
+		if protocol != "udp" && protocol != "tcp" {
+			return fmt.Errorf("you must specify a gameserver protocol using --protocol that is either udp or tcp")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := handleInterrupts(cancel, "stopping all workers", func() {})
+		defer stop()
+
+		coordinator := &distributed.Coordinator{
+			Workers: coordinatorWorkers,
+			Token:   workerToken,
+		}
+		opts := allocator.LoadOptions{
+			Count:       demoCount,
+			Delay:       time.Duration(demoDelay) * time.Second,
+			Duration:    time.Duration(demoDuration) * time.Second,
+			Protocol:    protocol,
+			GracePeriod: gracePeriod,
+		}
+		report, err := coordinator.Run(ctx, opts)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		report.Print(os.Stdout)
+
+		if coordinatorReportFile != "" {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				klog.Fatal(err)
+			}
+			err = ioutil.WriteFile(coordinatorReportFile, data, 0644)
+			if err != nil {
+				klog.Fatal(err)
+			}
+		}
+	},
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/kube"
+)
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+	rootCmd.AddCommand(shutdownCmd)
+}
+
+var releaseCmd = &cobra.Command{
+	Use:     "release [gameserver...]",
+	Short:   "release",
+	Long:    `Deletes allocated gameservers, by name or by --run-id, so that the fleet replaces them. Requires kubeconfig access to the cluster.`,
+	PreRunE: lifecycleArgsValidator,
+	Run: func(cmd *cobra.Command, args []string) {
+		kubeClient, names := lifecycleTargets(args)
+		klog.Infof("deleting %d gameservers", len(names))
+		err := kubeClient.DeleteGameServers(context.Background(), namespace, names)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	},
+}
+
+var shutdownCmd = &cobra.Command{
+	Use:     "shutdown [gameserver...]",
+	Short:   "shutdown",
+	Long:    `Marks allocated gameservers as Shutdown, by name or by --run-id, the same as the game calling Shutdown() through the SDK. Requires kubeconfig access to the cluster.`,
+	PreRunE: lifecycleArgsValidator,
+	Run: func(cmd *cobra.Command, args []string) {
+		kubeClient, names := lifecycleTargets(args)
+		klog.Infof("shutting down %d gameservers", len(names))
+		err := kubeClient.ShutdownGameServers(context.Background(), namespace, names)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	},
+}
+
+func lifecycleArgsValidator(cmd *cobra.Command, args []string) error {
+	if namespace == "" {
+		return fmt.Errorf("you must specify a namespace")
+	}
+	if len(args) == 0 && runID == "" {
+		return fmt.Errorf("you must pass gameserver names or a run ID with --run-id")
+	}
+	return nil
+}
+
+// lifecycleTargets returns the gameservers named in args, plus the ones labelled with --run-id
+func lifecycleTargets(args []string) (*kube.Client, []string) {
+	kubeClient, err := kube.NewClient(kubeconfig, kubeContext)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	names := append([]string{}, args...)
+	if runID != "" {
+		labelled, err := kubeClient.ListGameServers(context.Background(), namespace, kube.RunIDSelector(runID))
+		if err != nil {
+			klog.Fatal(err)
+		}
+		names = append(names, labelled...)
+	}
+	return kubeClient, names
+}
+
+// releaseRun deletes every gameserver labelled with the run ID
+func releaseRun(ctx context.Context, kubeClient *kube.Client, namespace string, runID string) error {
+	names, err := kubeClient.ListGameServers(ctx, namespace, kube.RunIDSelector(runID))
+	if err != nil {
+		return err
+	}
+	klog.Infof("deleting %d gameservers from run %s", len(names), runID)
+	return kubeClient.DeleteGameServers(ctx, namespace, names)
+}
+
+// newRunID returns a run ID that is unique enough to tell test runs apart
+func newRunID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(suffix))
+}
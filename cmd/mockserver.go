@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
+)
+
+var (
+	mockListen      string
+	mockPingListen  string
+	mockPlaintext   bool
+	mockCertDir     string
+	mockServerNames []string
+	mockFleetFile   string
+	mockReplicas    int
+	mockLabels      map[string]string
+	mockAddress     string
+	mockPortStart   int
+	mockNodes       int
+	mockGameServer  string
+	mockReadyAfter  time.Duration
+)
+
+// mockFleet is the format of the --fleet file
+type mockFleet struct {
+	GameServers []allocatortest.GameServer `json:"gameServers"`
+}
+
+func init() {
+	rootCmd.AddCommand(mockServerCmd)
+	mockServerCmd.PersistentFlags().StringVar(&mockListen, "listen", "127.0.0.1:8443", "The address to serve the allocation API on.")
+	mockServerCmd.PersistentFlags().StringVar(&mockPingListen, "ping-listen", "127.0.0.1:8080", "The address to serve HTTP pings on, for --hosts-ping. Set it to an empty string to turn pings off.")
+	mockServerCmd.PersistentFlags().BoolVar(&mockPlaintext, "plaintext", false, "Serve gRPC without TLS. The allocate commands always use mTLS, so this is only for other clients.")
+	mockServerCmd.PersistentFlags().StringVar(&mockCertDir, "cert-dir", "", "Write the generated client key, client cert and CA cert to this directory. Defaults to a new temporary directory.")
+	mockServerCmd.PersistentFlags().StringSliceVar(&mockServerNames, "server-names", nil, "Extra host names or IPs for the server certificate. It is always valid for localhost and 127.0.0.1.")
+	mockServerCmd.PersistentFlags().StringVar(&mockFleetFile, "fleet", "", "A YAML file with the gameservers to serve, under gameServers. Each one has a name, address, ports and optionally a namespace, labels, annotations and nodeName.")
+	mockServerCmd.PersistentFlags().IntVar(&mockReplicas, "replicas", 10, "The number of gameservers to generate when there is no --fleet file.")
+	mockServerCmd.PersistentFlags().StringToStringVar(&mockLabels, "fleet-labels", nil, "Labels to put on the generated gameservers.")
+	mockServerCmd.PersistentFlags().StringVar(&mockAddress, "gameserver-address", "127.0.0.1", "The address of the generated gameservers.")
+	mockServerCmd.PersistentFlags().IntVar(&mockPortStart, "gameserver-port", 7000, "The port of the first generated gameserver. Each one after that gets the next port, unless --game-server is set.")
+	mockServerCmd.PersistentFlags().IntVar(&mockNodes, "nodes", 1, "The number of nodes to spread the generated gameservers across, for Packed and Distributed scheduling.")
+	mockServerCmd.PersistentFlags().StringVar(&mockGameServer, "game-server", "", "Run a stand-in for the Agones simple game server, either udp or tcp, and point every generated gameserver at it, so load-test works offline.")
+	mockServerCmd.PersistentFlags().DurationVar(&mockReadyAfter, "ready-after", time.Minute, "How long a gameserver stays Allocated before it goes back to Ready. Zero keeps it allocated.")
+}
+
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server",
+	Short: "mock-server",
+	Long:  `Runs a local allocation service with an in-memory fleet, for developing against the allocator without Kubernetes. It generates throwaway certificates and prints the flags to use them.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if mockGameServer != "" && mockGameServer != "udp" && mockGameServer != "tcp" {
+			return fmt.Errorf("--game-server must be either udp or tcp")
+		}
+		if mockFleetFile == "" && mockReplicas < 1 {
+			return fmt.Errorf("you must pass --replicas of at least one or a --fleet file")
+		}
+		if mockNodes < 1 {
+			return fmt.Errorf("--nodes must be at least one")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := handleInterrupts(cancel, "stopping the mock server", func() {})
+		defer stop()
+
+		fleet, closeGameServer, err := mockFleetFromFlags()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer closeGameServer()
+
+		certs, err := allocatortest.NewCertificates(mockServerNames...)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		server, err := allocatortest.NewServer(allocatortest.Options{
+			Address:      mockListen,
+			Certificates: certs,
+			Insecure:     mockPlaintext,
+		})
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer server.Close()
+		server.AddGameServers(fleet...)
+		server.SetReadyAfter(mockReadyAfter)
+
+		if mockCertDir == "" {
+			mockCertDir, err = ioutil.TempDir("", "mock-allocator-")
+			if err != nil {
+				klog.Fatal(err)
+			}
+		}
+		key, cert, ca, err := certs.WriteClientFiles(mockCertDir)
+		if err != nil {
+			klog.Fatal(err)
+		}
+
+		hostsFlag := "--hosts " + server.Address
+		if mockPingListen != "" {
+			pingServer := &http.Server{Addr: mockPingListen, Handler: ping.Handler()}
+			go func() {
+				if err := pingServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					klog.Fatal(err)
+				}
+			}()
+			defer pingServer.Close()
+			hostsFlag = fmt.Sprintf("--hosts-ping %s=%s", server.Address, mockPingListen)
+		}
+
+		klog.Infof("serving %d gameservers on %s", len(fleet), server.Address)
+		fmt.Printf("Connect with:\n  --key %s --cert %s --ca-cert %s %s\n", key, cert, ca, hostsFlag)
+		<-ctx.Done()
+	},
+}
+
+// mockFleetFromFlags loads the --fleet file, or generates a fleet. The returned function stops the
+// stand-in gameserver if there is one.
+func mockFleetFromFlags() ([]allocatortest.GameServer, func(), error) {
+	noop := func() {}
+	if mockFleetFile != "" {
+		data, err := ioutil.ReadFile(mockFleetFile)
+		if err != nil {
+			return nil, noop, err
+		}
+		fleet := &mockFleet{}
+		if err := yaml.UnmarshalStrict(data, fleet); err != nil {
+			return nil, noop, fmt.Errorf("could not read fleet file %s - %s", mockFleetFile, err.Error())
+		}
+		return fleet.GameServers, noop, nil
+	}
+
+	address, port := mockAddress, mockPortStart
+	stop := noop
+	if mockGameServer != "" {
+		gameServer, err := allocatortest.NewSimpleGameServer(mockGameServer)
+		if err != nil {
+			return nil, noop, err
+		}
+		stop = gameServer.Close
+		target := gameServer.GameServer("")
+		address, port = target.Address, int(target.Ports[0].Port)
+		klog.Infof("simple %s game server listening on %s", mockGameServer, gameServer.Address)
+	}
+
+	fleet := make([]allocatortest.GameServer, 0, mockReplicas)
+	for i := 0; i < mockReplicas; i++ {
+		gsPort := port
+		if mockGameServer == "" {
+			gsPort = port + i
+		}
+		fleet = append(fleet, allocatortest.GameServer{
+			Name:     fmt.Sprintf("mock-gameserver-%d", i),
+			Labels:   mockLabels,
+			NodeName: fmt.Sprintf("mock-node-%d", i%mockNodes),
+			Address:  address,
+			Ports:    []allocatortest.Port{{Name: "default", Port: int32(gsPort)}},
+		})
+	}
+	return fleet, stop, nil
+}
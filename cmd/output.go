@@ -0,0 +1,185 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+// envPrefix is put in front of the variables printed by --output env
+const envPrefix = "ALLOCATION_"
+
+var nonEnvChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// validateOutput checks the output format and parses the template if there is one. The
+// allocate command keeps the result in outputTmpl, so the template is only parsed once.
+func validateOutput(format string, text string) (*template.Template, error) {
+	switch format {
+	case "", "json", "yaml", "env":
+		return nil, nil
+	case "template":
+		if text == "" {
+			return nil, fmt.Errorf("you must pass a template with --template when using --output template")
+		}
+		return template.New("output").Parse(text)
+	default:
+		return nil, fmt.Errorf("output must be one of json, yaml, env or template")
+	}
+}
+
+// writeAllocation prints an allocation in the requested format
+func writeAllocation(w io.Writer, format string, tmpl *template.Template, allocation *allocator.Allocation) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(allocation, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(allocation)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "env":
+		for _, line := range allocationEnv(envPrefix, allocation) {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "template":
+		err := tmpl.Execute(w, allocation)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w)
+		return err
+	default:
+		_, err := fmt.Fprintf(w, "Got allocation %s %d\n", allocation.Address, allocation.Port)
+		return err
+	}
+}
+
+// batchItem is one allocation of a batch in json or yaml output
+type batchItem struct {
+	*allocator.Allocation
+	Error string `json:"error,omitempty"`
+}
+
+// writeAllocations prints the results of a batch allocation in the requested format. Text and
+// template output have a line per allocation, and failures are logged instead.
+func writeAllocations(w io.Writer, format string, tmpl *template.Template, results []allocator.AllocationResult) error {
+	switch format {
+	case "json", "yaml":
+		items := make([]batchItem, 0, len(results))
+		for _, result := range results {
+			item := batchItem{Allocation: result.Allocation}
+			if result.Err != nil {
+				item.Error = result.Err.Error()
+			}
+			items = append(items, item)
+		}
+		var data []byte
+		var err error
+		if format == "json" {
+			data, err = json.MarshalIndent(items, "", "  ")
+			data = append(data, '\n')
+		} else {
+			data, err = yaml.Marshal(items)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "env":
+		lines := []string{fmt.Sprintf("export %sCOUNT=%s", envPrefix, shellQuote(fmt.Sprint(len(results))))}
+		for i, result := range results {
+			prefix := fmt.Sprintf("%s%d_", envPrefix, i)
+			if result.Err != nil {
+				lines = append(lines, fmt.Sprintf("export %sERROR=%s", prefix, shellQuote(result.Err.Error())))
+				continue
+			}
+			lines = append(lines, allocationEnv(prefix, result.Allocation)...)
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		for i, result := range results {
+			if result.Err != nil {
+				klog.Errorf("allocation %d failed - %s", i, result.Err.Error())
+				continue
+			}
+			if err := writeAllocation(w, format, tmpl, result.Allocation); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// allocationEnv returns shell export statements for an allocation, with a variable for each named port
+func allocationEnv(prefix string, allocation *allocator.Allocation) []string {
+	vars := [][2]string{
+		{"GAMESERVER_NAME", allocation.GameServerName},
+		{"NODE_NAME", allocation.NodeName},
+		{"ADDRESS", allocation.Address},
+		{"PORT", fmt.Sprint(allocation.Port)},
+		{"ENDPOINT", allocation.Endpoint},
+		{"ATTEMPTS", fmt.Sprint(allocation.Attempts)},
+		{"LATENCY", allocation.Latency.String()},
+	}
+	names := make([]string, 0, len(allocation.Ports))
+	for name := range allocation.Ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "PORT_" + nonEnvChars.ReplaceAllString(strings.ToUpper(name), "_")
+		vars = append(vars, [2]string{key, fmt.Sprint(allocation.Ports[name])})
+	}
+
+	lines := make([]string, 0, len(vars))
+	for _, v := range vars {
+		lines = append(lines, fmt.Sprintf("export %s%s=%s", prefix, v[0], shellQuote(v[1])))
+	}
+	return lines
+}
+
+// shellQuote wraps a value in single quotes so that it is safe to eval
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
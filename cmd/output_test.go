@@ -0,0 +1,154 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		text     string
+		wantTmpl bool
+		wantErr  bool
+	}{
+		{name: "default", format: ""},
+		{name: "json", format: "json"},
+		{name: "template", format: "template", text: "{{.Address}}:{{.Port}}", wantTmpl: true},
+		{name: "template without text", format: "template", wantErr: true},
+		{name: "invalid template", format: "template", text: "{{.Address", wantErr: true},
+		{name: "unknown format", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := validateOutput(tt.format, tt.text)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantTmpl, tmpl != nil)
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "gs-1", want: `'gs-1'`},
+		{name: "empty", value: "", want: `''`},
+		{name: "spaces", value: "a b  c", want: `'a b  c'`},
+		{name: "single quote", value: "it's", want: `'it'\''s'`},
+		{name: "double quote", value: `say "hi"`, want: `'say "hi"'`},
+		{name: "newline", value: "one\ntwo", want: "'one\ntwo'"},
+		{name: "shell syntax", value: "$(rm -rf /); `x`", want: "'$(rm -rf /); `x`'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shellQuote(tt.value)
+			assert.Equal(t, tt.want, got)
+
+			// The shell reads the value back exactly
+			out, err := exec.Command("sh", "-c", `eval "v=$1"; printf '%s' "$v"`, "sh", got).Output()
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, string(out))
+		})
+	}
+}
+
+func TestAllocationEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		allocation *allocator.Allocation
+		want       []string
+	}{
+		{
+			name:   "ports are sorted and named after the port",
+			prefix: "ALLOCATION_",
+			allocation: &allocator.Allocation{
+				GameServerName: "gs-1",
+				NodeName:       "node-1",
+				Address:        "10.0.0.1",
+				Port:           7000,
+				Ports:          map[string]int32{"game": 7000, "voice-chat": 7001},
+				Endpoint:       "east:443",
+				Attempts:       1,
+				Latency:        20 * time.Millisecond,
+			},
+			want: []string{
+				"export ALLOCATION_GAMESERVER_NAME='gs-1'",
+				"export ALLOCATION_NODE_NAME='node-1'",
+				"export ALLOCATION_ADDRESS='10.0.0.1'",
+				"export ALLOCATION_PORT='7000'",
+				"export ALLOCATION_ENDPOINT='east:443'",
+				"export ALLOCATION_ATTEMPTS='1'",
+				"export ALLOCATION_LATENCY='20ms'",
+				"export ALLOCATION_PORT_GAME='7000'",
+				"export ALLOCATION_PORT_VOICE_CHAT='7001'",
+			},
+		},
+		{
+			name:   "values with quotes, spaces and newlines are quoted",
+			prefix: "ALLOCATION_0_",
+			allocation: &allocator.Allocation{
+				GameServerName: "it's a\nserver",
+				Address:        `"10.0.0.1"`,
+				Ports:          map[string]int32{"my port": 7000},
+			},
+			want: []string{
+				"export ALLOCATION_0_GAMESERVER_NAME='it'\\''s a\nserver'",
+				"export ALLOCATION_0_NODE_NAME=''",
+				`export ALLOCATION_0_ADDRESS='"10.0.0.1"'`,
+				"export ALLOCATION_0_PORT='0'",
+				"export ALLOCATION_0_ENDPOINT=''",
+				"export ALLOCATION_0_ATTEMPTS='0'",
+				"export ALLOCATION_0_LATENCY='0s'",
+				"export ALLOCATION_0_PORT_MY_PORT='7000'",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocationEnv(tt.prefix, tt.allocation)
+			assert.Equal(t, tt.want, got)
+
+			// Evaluating the lines sets the variables to the original values
+			var script bytes.Buffer
+			for _, line := range got {
+				script.WriteString(line + "\n")
+			}
+			script.WriteString(`printf '%s' "$` + tt.prefix + `GAMESERVER_NAME"`)
+			out, err := exec.Command("sh", "-c", script.String()).Output()
+			require.NoError(t, err)
+			assert.Equal(t, tt.allocation.GameServerName, string(out))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
+)
+
+var (
+	pingHTTPListen string
+	pingUDPListen  string
+	pingLatency    time.Duration
+	pingJitter     time.Duration
+	pingLoss       float64
+)
+
+func init() {
+	rootCmd.AddCommand(pingServerCmd)
+	pingServerCmd.PersistentFlags().StringVar(&pingHTTPListen, "http-listen", ":8080", "The address to answer HTTP pings on. Set it to an empty string to turn HTTP off.")
+	pingServerCmd.PersistentFlags().StringVar(&pingUDPListen, "udp-listen", ":8080", "The address to echo UDP pings on. Set it to an empty string to turn UDP off.")
+	pingServerCmd.PersistentFlags().DurationVar(&pingLatency, "latency", 0, "Latency to add to every reply.")
+	pingServerCmd.PersistentFlags().DurationVar(&pingJitter, "jitter", 0, "Up to this much more latency is added to each reply at random.")
+	pingServerCmd.PersistentFlags().Float64Var(&pingLoss, "loss", 0, "The chance, from 0 to 1, that a ping is never answered.")
+}
+
+var pingServerCmd = &cobra.Command{
+	Use:   "ping-server",
+	Short: "ping-server",
+	Long:  `Runs a ping service compatible with the Agones HTTP and UDP ping services, with optional latency and packet loss. Run one per simulated region and point --hosts-ping at them.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if pingHTTPListen == "" && pingUDPListen == "" {
+			return fmt.Errorf("you must set at least one of --http-listen or --udp-listen")
+		}
+		if pingLoss < 0 || pingLoss > 1 {
+			return fmt.Errorf("--loss must be between 0 and 1")
+		}
+		if pingLatency < 0 || pingJitter < 0 {
+			return fmt.Errorf("--latency and --jitter cannot be negative")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := handleInterrupts(cancel, "stopping the ping server", func() {})
+		defer stop()
+
+		server := ping.NewServer(ping.Faults{Latency: pingLatency, Jitter: pingJitter, Loss: pingLoss})
+		errs := make(chan error, 2)
+
+		if pingHTTPListen != "" {
+			httpServer := &http.Server{Addr: pingHTTPListen, Handler: server}
+			go func() {
+				klog.Infof("answering http pings on %s", pingHTTPListen)
+				if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+					errs <- err
+				}
+			}()
+			defer httpServer.Close()
+		}
+		if pingUDPListen != "" {
+			conn, err := net.ListenPacket("udp", pingUDPListen)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			go func() {
+				klog.Infof("answering udp pings on %s", conn.LocalAddr())
+				if err := server.ServeUDP(ctx, conn); err != nil {
+					errs <- err
+				}
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+		case err := <-errs:
+			klog.Fatal(err)
+		}
+	},
+}
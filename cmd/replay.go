@@ -0,0 +1,118 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+	"github.com/fairwindsops/agones-allocator-client/pkg/kube"
+)
+
+var (
+	replayFile      string
+	replaySpeed     float64
+	replayNamespace string
+	replayFree      bool
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.PersistentFlags().StringVarP(&replayFile, "file", "f", "", "The file of recorded allocation requests to replay, as written by --record.")
+	replayCmd.PersistentFlags().Float64Var(&replaySpeed, "speed", 1, "Scales the recorded timing. 2 replays the traffic twice as fast. Zero sends every request immediately.")
+	replayCmd.PersistentFlags().StringVar(&replayNamespace, "replace-namespace", "", "If set, replaces the namespace of every recorded request.")
+	replayCmd.PersistentFlags().BoolVar(&replayFree, "free", false, "Delete the allocated gameservers when the replay is done. Requires kubeconfig access to the cluster.")
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "replay",
+	Long:  `Re-issues recorded allocation requests against the allocator, with their original timing or scaled up.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if replayFile == "" {
+			return fmt.Errorf("you must pass a file to replay with --file")
+		}
+		if replaySpeed < 0 {
+			return fmt.Errorf("speed cannot be negative")
+		}
+		return argsValidator(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(replayFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		records, err := allocator.ReadRecords(f)
+		f.Close()
+		if err != nil {
+			klog.Fatal(err)
+		}
+
+		var kubeClient *kube.Client
+		if replayFree {
+			kubeClient, err = kube.NewClient(kubeconfig, kubeContext)
+			if err != nil {
+				klog.Fatal(err)
+			}
+		}
+
+		allocatorClient, err := newAllocatorClient()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer closeRecorder(allocatorClient)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		report := allocator.NewBenchReport()
+		stop := handleInterrupts(cancel, "stopping the replay", func() {
+			report.Summary().Print(os.Stdout)
+		})
+		defer stop()
+
+		opts := allocator.ReplayOptions{
+			Speed:     replaySpeed,
+			Namespace: replayNamespace,
+		}
+		klog.Infof("replaying %d requests", len(records))
+		err = allocatorClient.Replay(ctx, records, opts, report)
+		if err != nil {
+			closeRecorder(allocatorClient)
+			klog.Fatal(err)
+		}
+
+		summary := report.Summary()
+		summary.Print(os.Stdout)
+
+		if kubeClient != nil && len(summary.GameServers) > 0 {
+			// Replayed requests keep their recorded namespaces unless --replace-namespace is set
+			for freeNamespace, names := range summary.GameServersByNamespace() {
+				klog.Infof("deleting %d allocated gameservers in %s", len(names), freeNamespace)
+				err = kubeClient.DeleteGameServers(context.Background(), freeNamespace, names)
+				if err != nil {
+					klog.Fatal(err)
+				}
+			}
+		}
+	},
+}
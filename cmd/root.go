@@ -17,42 +17,102 @@ limitations under the License
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	pb "agones.dev/agones/pkg/allocation/go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/klog"
 
 	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+	"github.com/fairwindsops/agones-allocator-client/pkg/config"
+	"github.com/fairwindsops/agones-allocator-client/pkg/dashboard"
+	"github.com/fairwindsops/agones-allocator-client/pkg/kube"
 	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
 )
 
 var (
-	version         string
-	versionCommit   string
-	keyFile         string
-	certFile        string
-	caCertFile      string
-	hosts           []string
-	pingServers     map[string]string
-	namespace       string
-	multicluster    bool
-	demoCount       int
-	demoDelay       int
-	demoDuration    int
-	labelSelector   map[string]string
-	pingTargets     []string
-	maxRetries      int
-	protocol        string
-	metaLabels      map[string]string
-	metaAnnotations map[string]string
+	version             string
+	versionCommit       string
+	keyFile             string
+	certFile            string
+	caCertFile          string
+	hosts               []string
+	pingServers         map[string]string
+	namespace           string
+	multicluster        bool
+	demoCount           int
+	demoDelay           int
+	demoDuration        int
+	labelSelector       map[string]string
+	pingTargets         []string
+	maxRetries          int
+	protocol            string
+	metaLabels          map[string]string
+	metaAnnotations     map[string]string
+	gracePeriod         time.Duration
+	kubeconfig          string
+	kubeContext         string
+	showDashboard       bool
+	dashboardEvery      time.Duration
+	recordFile          string
+	transportName       string
+	clientSecret        string
+	caSecret            string
+	discoverHost        bool
+	agonesNamespace     string
+	allocatorSvc        string
+	certReload          time.Duration
+	tlsServerName       string
+	tlsMinVersion       string
+	tlsCiphers          []string
+	insecureSkip        bool
+	authToken           string
+	authTokenFile       string
+	authTokenExec       string
+	authTokenTTL        time.Duration
+	authHeader          string
+	authScheme          string
+	configFile          string
+	profileName         string
+	outputFormat        string
+	outputTemplate      string
+	outputTmpl          *template.Template
+	allocateCount       int
+	allocateConcurrency int
+	runID               string
+	loadCleanup         bool
+	faultsFile          string
+	rateLimit           float64
+	rateBurst           int
+	maxInFlight         int
+	endpointRateLimits  map[string]string
+	endpointMaxInFlight map[string]int
+	breakerFailures     int
+	breakerProbe        time.Duration
+	hedgeDelay          time.Duration
+	hedgeRelease        bool
+	hedgeTimeout        time.Duration
+	endpointKubeContext map[string]string
+	rerankInterval      time.Duration
+	rerankSmoothing     float64
+	rerankHysteresis    float64
 )
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "The config file to read profiles from. Defaults to agones-allocator-client/config.yaml in the user config directory, e.g. ~/.config")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "The profile in the config file to use. Defaults to the currentProfile in the file.")
 	rootCmd.PersistentFlags().StringVarP(&keyFile, "key", "", "", "The path to the client key file in PEM format")
 	rootCmd.PersistentFlags().StringVarP(&certFile, "cert", "", "", "The path the client cert file in PEM format")
 	rootCmd.PersistentFlags().StringVar(&caCertFile, "ca-cert", "", "The path the CA cert file in PEM format")
@@ -62,52 +122,89 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&multicluster, "multicluster", "m", false, "If true, multicluster allocation will be requested")
 	rootCmd.PersistentFlags().StringToStringVar(&labelSelector, "labels-required", nil, "A map of labels to match on the allocation.")
 	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", 10, "The maximum number of times to retry allocations.")
+	rootCmd.PersistentFlags().DurationVar(&certReload, "cert-reload-interval", 0, "If set, the key, cert and CA cert files are checked for changes on this interval and reloaded without a restart. Zero disables reloading.")
+	rootCmd.PersistentFlags().StringVar(&tlsServerName, "tls-server-name", "", "Verify the allocator certificate against this name instead of the host. Use it when reaching the allocator by IP or through a tunnel.")
+	rootCmd.PersistentFlags().StringVar(&tlsMinVersion, "tls-min-version", "", "The minimum TLS version to use with the allocator. One of 1.0, 1.1, 1.2 or 1.3. Defaults to the Go default.")
+	rootCmd.PersistentFlags().StringSliceVar(&tlsCiphers, "tls-cipher-suites", nil, "A list of cipher suites to offer for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Defaults to the Go default.")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkip, "insecure-skip-verify", false, "DANGEROUS: do not verify the allocator certificate at all. Only for lab clusters.")
+	rootCmd.PersistentFlags().StringVar(&authToken, "token", "", "A token to send with every allocation request, for gateways that want one on top of the client certificate.")
+	rootCmd.PersistentFlags().StringVar(&authTokenFile, "token-file", "", "Read the token from this file. It is read again whenever it changes.")
+	rootCmd.PersistentFlags().StringVar(&authTokenExec, "token-exec", "", "Run this command with sh -c to get the token. It prints either the token or JSON like {\"token\": \"...\", \"expiry\": \"<RFC3339>\"}.")
+	rootCmd.PersistentFlags().DurationVar(&authTokenTTL, "token-exec-ttl", 5*time.Minute, "How long to reuse a token from --token-exec that has no expiry.")
+	rootCmd.PersistentFlags().StringVar(&authHeader, "token-header", allocator.DefaultTokenHeader, "The header or gRPC metadata key to send the token in, e.g. x-api-key.")
+	rootCmd.PersistentFlags().StringVar(&authScheme, "token-scheme", "Bearer", "Put in front of the token in the header. Set it to an empty string to send the token on its own.")
+	rootCmd.PersistentFlags().StringVar(&clientSecret, "client-secret", "", "Read the client key and cert from this kubernetes TLS secret instead of --key and --cert. Either name (in --namespace) or namespace/name, e.g. allocator-client.default")
+	rootCmd.PersistentFlags().StringVar(&caSecret, "ca-secret", "", "Read the CA cert from this kubernetes secret instead of --ca-cert. Either name (in --agones-namespace) or namespace/name, e.g. allocator-tls-ca")
+	rootCmd.PersistentFlags().BoolVar(&discoverHost, "discover-allocator", false, "If no hosts are set, use the external address of the allocator service in the cluster.")
+	rootCmd.PersistentFlags().StringVar(&agonesNamespace, "agones-namespace", kube.DefaultAgonesNamespace, "The namespace Agones is installed in.")
+	rootCmd.PersistentFlags().StringVar(&allocatorSvc, "allocator-service", kube.DefaultAllocatorService, "The name of the allocator service to discover.")
+	rootCmd.PersistentFlags().StringVar(&transportName, "transport", "grpc", "How to talk to the allocator. Either grpc or rest. Use rest when a proxy in the way does not pass gRPC.")
+	rootCmd.PersistentFlags().StringVar(&runID, "run-id", "", "Label every allocated gameserver with this run ID, so that release and shutdown can find them later with the same flag.")
+	rootCmd.PersistentFlags().StringVar(&faultsFile, "faults", "", "TESTING ONLY: inject latency, errors, dropped responses and blackholed endpoints into allocation requests, as described in this YAML file.")
+	rootCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", 0, "The most allocation requests per second to send, across all hosts. Zero means no limit.")
+	rootCmd.PersistentFlags().IntVar(&rateBurst, "rate-burst", 1, "How many requests can be sent back to back before --rate-limit or --endpoint-rate-limit applies.")
+	rootCmd.PersistentFlags().IntVar(&maxInFlight, "max-in-flight", 0, "The most allocation requests waiting on a response at once, across all hosts. Zero means no limit.")
+	rootCmd.PersistentFlags().StringToStringVar(&endpointRateLimits, "endpoint-rate-limit", nil, "A map of hosts and the most requests per second to send to each, on top of --rate-limit.")
+	rootCmd.PersistentFlags().StringToIntVar(&endpointMaxInFlight, "endpoint-max-in-flight", nil, "A map of hosts and the most requests in flight to each, on top of --max-in-flight.")
+	rootCmd.PersistentFlags().IntVar(&breakerFailures, "circuit-breaker-failures", 0, "Open the circuit for a host after this many failures in a row, and send requests to the other hosts until it recovers. Zero turns circuit breaking off.")
+	rootCmd.PersistentFlags().DurationVar(&breakerProbe, "circuit-breaker-probe-interval", allocator.DefaultBreakerProbeInterval, "How long an open circuit waits before letting a request through to check whether the host has recovered.")
+	rootCmd.PersistentFlags().DurationVar(&hedgeDelay, "hedge-delay", 0, "If a host has not answered after this long, send the same request to the next-best host and take the first success. Zero turns hedging off.")
+	rootCmd.PersistentFlags().DurationVar(&hedgeTimeout, "hedge-timeout", allocator.DefaultHedgeTimeout, "How long the slower hedged request may keep running after the faster one won. Commands wait this long for it before exiting.")
+	rootCmd.PersistentFlags().BoolVar(&hedgeRelease, "hedge-release", false, "Delete the extra gameserver when both hedged requests succeed, in the cluster given for its host by --endpoint-kube-context. Without it, extra gameservers are only logged.")
+	rootCmd.PersistentFlags().StringToStringVar(&endpointKubeContext, "endpoint-kube-context", nil, "A map of hosts and the kubeconfig context of the cluster behind each. Required for every host with --hedge-release.")
+	rootCmd.PersistentFlags().DurationVar(&rerankInterval, "rerank-interval", 0, "Ping the hosts-ping servers again on this interval, and switch to a host that has become clearly faster. Hosts that were dropped because their ping server did not answer are added back once it does. Zero turns re-ranking off.")
+	rootCmd.PersistentFlags().Float64Var(&rerankSmoothing, "rerank-smoothing", allocator.DefaultRerankSmoothing, "The weight, more than 0 and at most 1, of the newest ping in a host's score. Lower values ride out short spikes.")
+	rootCmd.PersistentFlags().Float64Var(&rerankHysteresis, "rerank-hysteresis", allocator.DefaultRerankHysteresis, "How much faster another host has to be, as a fraction of the current host's score, before switching to it. Must be more than 0 and less than 1.")
+	rootCmd.PersistentFlags().StringVar(&recordFile, "record", "", "If set, every allocation request and its outcome is appended to this file as JSON lines.")
+	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "The kubeconfig to use when talking to the cluster directly. Defaults to the usual kubectl loading rules.")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "kube-context", "", "The kubeconfig context to use. Defaults to the current context.")
 
 	rootCmd.AddCommand(allocateCmd)
 	allocateCmd.PersistentFlags().StringToStringVar(&metaLabels, "meta-labels", nil, "A map of labels to add to the gameserver on allocation")
 	allocateCmd.PersistentFlags().StringToStringVar(&metaAnnotations, "meta-annotations", nil, "A map of annotations to add to the gameserver on allocation")
+	allocateCmd.PersistentFlags().IntVar(&allocateCount, "count", 1, "The number of gameservers to allocate with the same settings. With more than one, the output is a list.")
+	allocateCmd.PersistentFlags().IntVar(&allocateConcurrency, "concurrency", 1, "The maximum number of allocations in flight when --count is more than one.")
+	allocateCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "", "The output format. One of json, yaml, env or template. Defaults to a line of text.")
+	allocateCmd.PersistentFlags().StringVar(&outputTemplate, "template", "", "A Go template for --output template, e.g. '{{.Address}}:{{.Port}}' or '{{.Address}}:{{index .Ports \"default\"}}'")
 
 	rootCmd.AddCommand(loadTestCmd)
 	loadTestCmd.PersistentFlags().IntVarP(&demoCount, "count", "c", 10, "The number of connections to make during the demo.")
 	loadTestCmd.PersistentFlags().IntVar(&demoDelay, "delay", 2, "The number of seconds to wait between connections")
 	loadTestCmd.PersistentFlags().IntVarP(&demoDuration, "duration", "d", 10, "The number of seconds to leave each connection open.")
 	loadTestCmd.PersistentFlags().StringVar(&protocol, "protocol", "udp", "The gameserver protocol. Either tcp or udp")
+	loadTestCmd.PersistentFlags().BoolVar(&showDashboard, "dashboard", true, "Show a live view of the test. It is turned off automatically when stdout is not a terminal.")
+	loadTestCmd.PersistentFlags().DurationVar(&dashboardEvery, "dashboard-interval", time.Second, "How often to redraw the dashboard.")
+	loadTestCmd.PersistentFlags().BoolVar(&loadCleanup, "cleanup", false, "Delete the gameservers allocated by the test when it is done. A run ID is generated if --run-id is not set. Requires kubeconfig access to the cluster.")
+	loadTestCmd.PersistentFlags().DurationVar(&gracePeriod, "grace-period", 15*time.Second, "How long to wait for open sessions to close after an interrupt. A second interrupt exits immediately.")
 
 	rootCmd.AddCommand(pingTestCmd)
 	pingTestCmd.PersistentFlags().StringSliceVarP(&pingTargets, "targets", "t", nil, "The list of targets to ping.")
 
 	klog.InitFlags(nil)
 	pflag.CommandLine.AddGoFlag(flag.CommandLine.Lookup("v"))
+}
 
-	envMap := map[string]string{
-		"AGONES_CLIENT_CERT":  "cert",
-		"AGONES_CLIENT_KEY":   "key",
-		"AGONES_CA_CERT":      "ca-cert",
-		"AGONES_HOSTS":        "hosts",
-		"AGONES_HOSTS_PING":   "hosts-ping",
-		"AGONES_GS_NAMESPACE": "namespace",
-	}
+// exclusiveFlags are groups of flags of which only one may be set. Setting one on the command line
+// or in the environment overrides the others in a profile.
+var exclusiveFlags = [][]string{
+	{"hosts", "hosts-ping"},
+	{"token", "token-file", "token-exec"},
+}
 
-	for env, flagName := range envMap {
-		flag := rootCmd.PersistentFlags().Lookup(flagName)
-		if flag == nil {
-			klog.Errorf("Could not find flag %s", flagName)
-			continue
-		}
-		flag.Usage = fmt.Sprintf("%v [%v]", flag.Usage, env)
-		if value := os.Getenv(env); value != "" {
-			err := flag.Value.Set(value)
-			if err != nil {
-				klog.Errorf("Error setting flag %v to %s from environment variable %s", flag, value, env)
-			}
-		}
-	}
+// envNames are the environment variables that predate the generated AGONES_<FLAG> names
+var envNames = map[string]string{
+	"cert":      "AGONES_CLIENT_CERT",
+	"key":       "AGONES_CLIENT_KEY",
+	"namespace": "AGONES_GS_NAMESPACE",
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "agones-allocator-client",
 	Short: "agones-allocator-client",
 	Long:  `A tool to test the agones allocator service`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return applyProfile(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("You must specify a sub-command.")
 		err := cmd.Help()
@@ -119,26 +216,74 @@ var rootCmd = &cobra.Command{
 }
 
 var allocateCmd = &cobra.Command{
-	Use:     "allocate",
-	Short:   "allocate",
-	Long:    `Request an allocated server`,
-	PreRunE: argsValidator,
+	Use:   "allocate",
+	Short: "allocate",
+	Long:  `Request an allocated server`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		outputTmpl, err = validateOutput(outputFormat, outputTemplate)
+		if err != nil {
+			return err
+		}
+		if allocateCount < 1 {
+			return fmt.Errorf("count must be at least 1")
+		}
+		return argsValidator(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		allocatorClient, err := allocator.NewClient(keyFile, certFile, caCertFile, namespace, multicluster, labelSelector, hosts, pingServers, maxRetries)
+		allocatorClient, err := newAllocatorClient()
 		if err != nil {
 			klog.Fatal(err)
 		}
+		defer closeRecorder(allocatorClient)
 
-		allocatorClient.MetaPatch = &pb.MetaPatch{
-			Labels:      metaLabels,
-			Annotations: metaAnnotations,
+		if allocatorClient.MetaPatch == nil {
+			allocatorClient.MetaPatch = &pb.MetaPatch{}
+		}
+		if allocatorClient.MetaPatch.Labels == nil {
+			allocatorClient.MetaPatch.Labels = map[string]string{}
+		}
+		for k, v := range metaLabels {
+			allocatorClient.MetaPatch.Labels[k] = v
 		}
+		allocatorClient.MetaPatch.Annotations = metaAnnotations
 
-		allocation, err := allocatorClient.AllocateGameserverWithRetry()
+		if allocateCount == 1 {
+			allocation, err := allocatorClient.AllocateGameserverWithRetry()
+			if err != nil {
+				klog.Fatal(err)
+			}
+			err = writeAllocation(os.Stdout, outputFormat, outputTmpl, allocation)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			waitForHedges(allocatorClient)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := handleInterrupts(cancel, "not starting any more allocations", func() {})
+		defer stop()
+
+		allocatorClient.Concurrency = allocateConcurrency
+		results := allocatorClient.AllocateMany(ctx, allocateCount)
+		err = writeAllocations(os.Stdout, outputFormat, outputTmpl, results)
 		if err != nil {
 			klog.Fatal(err)
 		}
-		fmt.Printf("Got allocation %s %d\n", allocation.Address, allocation.Port)
+		waitForHedges(allocatorClient)
+		failed := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			klog.Errorf("%d of %d allocations failed", failed, allocateCount)
+			closeRecorder(allocatorClient)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -148,12 +293,72 @@ var loadTestCmd = &cobra.Command{
 	Long:    `Allocates a set of servers, communicates with them, and then closes the connection.`,
 	PreRunE: argsValidator,
 	Run: func(cmd *cobra.Command, args []string) {
-		allocatorClient, err := allocator.NewClient(keyFile, certFile, caCertFile, namespace, multicluster, labelSelector, hosts, pingServers, maxRetries)
+		var kubeClient *kube.Client
+		if loadCleanup {
+			// Fail before allocating anything if the gameservers can't be cleaned up
+			var err error
+			kubeClient, err = kube.NewClient(kubeconfig, kubeContext)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			if runID == "" {
+				runID = newRunID()
+			}
+			klog.Infof("labelling gameservers with run ID %s", runID)
+		}
+
+		allocatorClient, err := newAllocatorClient()
 		if err != nil {
 			klog.Fatal(err)
 		}
-		err = allocatorClient.RunLoad(demoCount, demoDelay, demoDuration, protocol)
+		defer closeRecorder(allocatorClient)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		report := allocator.NewLoadReport()
+		stop := handleInterrupts(cancel, fmt.Sprintf("closing open sessions, waiting up to %s", gracePeriod), func() {
+			report.Print(os.Stdout)
+		})
+		defer stop()
+
+		opts := allocator.LoadOptions{
+			Count:       demoCount,
+			Delay:       time.Duration(demoDelay) * time.Second,
+			Duration:    time.Duration(demoDuration) * time.Second,
+			Protocol:    protocol,
+			GracePeriod: gracePeriod,
+		}
+		stopDashboard := func() {}
+		if showDashboard && dashboard.IsTerminal(os.Stdout) {
+			dashboardCtx, cancelDashboard := context.WithCancel(context.Background())
+			finished := make(chan struct{})
+			board := &dashboard.Dashboard{
+				Out:      os.Stdout,
+				Interval: dashboardEvery,
+				Progress: report.Progress,
+			}
+			go func() {
+				board.Run(dashboardCtx)
+				close(finished)
+			}()
+			stopDashboard = func() {
+				cancelDashboard()
+				<-finished
+			}
+		}
+
+		err = allocatorClient.RunLoad(ctx, opts, report)
+		waitForHedges(allocatorClient)
+		stopDashboard()
+		report.Print(os.Stdout)
+		if kubeClient != nil {
+			cleanupErr := releaseRun(context.Background(), kubeClient, namespace, runID)
+			if cleanupErr != nil {
+				klog.Errorf("cleanup failed - run release --run-id %s to try again: %s", runID, cleanupErr.Error())
+			}
+		}
 		if err != nil {
+			closeRecorder(allocatorClient)
 			klog.Fatal(err)
 		}
 	},
@@ -196,12 +401,333 @@ var pingTestCmd = &cobra.Command{
 func Execute(VERSION string, COMMIT string) {
 	version = VERSION
 	versionCommit = COMMIT
+	addEnvUsage(rootCmd, map[*pflag.Flag]bool{})
 	if err := rootCmd.Execute(); err != nil {
 		klog.Error(err)
 		os.Exit(1)
 	}
 }
 
+// addEnvUsage adds the environment variable for each flag to its usage. It runs after every
+// command's init has added its flags.
+func addEnvUsage(cmd *cobra.Command, done map[*pflag.Flag]bool) {
+	add := func(flag *pflag.Flag) {
+		if done[flag] || flag.Name == "help" {
+			return
+		}
+		done[flag] = true
+		flag.Usage = fmt.Sprintf("%v [%v]", flag.Usage, envName(flag.Name))
+	}
+	cmd.PersistentFlags().VisitAll(add)
+	cmd.LocalNonPersistentFlags().VisitAll(add)
+	for _, child := range cmd.Commands() {
+		addEnvUsage(child, done)
+	}
+}
+
+func envName(flagName string) string {
+	if env, ok := envNames[flagName]; ok {
+		return env
+	}
+	return config.EnvName(flagName)
+}
+
+// applyProfile fills in every flag that was not passed on the command line, from its
+// environment variable or else from the profile in the config file
+func applyProfile(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	path := flagOrEnv(flags, "config")
+	optional := path == ""
+	if optional {
+		path = config.DefaultPath()
+	}
+	file, err := config.Load(path, optional)
+	if err != nil {
+		return err
+	}
+	profile, err := file.Profile(flagOrEnv(flags, "profile"))
+	if err != nil {
+		return err
+	}
+	err = profile.Validate(allFlags(cmd.Root())...)
+	if err != nil {
+		return err
+	}
+	return config.Apply(flags, profile.WithoutConflicts(flags, envNames, exclusiveFlags...), envNames)
+}
+
+// flagOrEnv returns the value of a flag from the command line, or else from its environment variable
+func flagOrEnv(flags *pflag.FlagSet, name string) string {
+	flag := flags.Lookup(name)
+	if flag.Changed {
+		return flag.Value.String()
+	}
+	return os.Getenv(envName(name))
+}
+
+// allFlags returns the flag sets of a command and all of its sub-commands
+func allFlags(cmd *cobra.Command) []*pflag.FlagSet {
+	flagSets := []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags()}
+	for _, child := range cmd.Commands() {
+		flagSets = append(flagSets, allFlags(child)...)
+	}
+	return flagSets
+}
+
+// newAllocatorClient builds an allocator client from the flags
+func newAllocatorClient() (*allocator.Client, error) {
+	key, cert, ca, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	hostList, err := allocatorHosts()
+	if err != nil {
+		return nil, err
+	}
+	allocatorClient, err := allocator.NewClientFromPEM(key, cert, ca, namespace, multicluster, labelSelector, hostList, pingServers, maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if certReload > 0 {
+		reloader, err := allocator.NewCertReloader(keyFile, certFile, caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		allocatorClient.UseCertReloader(reloader)
+		go reloader.Run(context.Background(), certReload)
+	}
+	tlsOptions, err := tlsOptionsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	allocatorClient.ConfigureTLS(tlsOptions)
+	allocatorClient.Credentials = tokenAuthFromFlags()
+	allocatorClient.Transport, err = allocatorClient.TransportFor(transportName)
+	if err != nil {
+		return nil, err
+	}
+	if runID != "" {
+		allocatorClient.MetaPatch = &pb.MetaPatch{
+			Labels: map[string]string{kube.RunIDLabel: runID},
+		}
+	}
+	if faultsFile != "" {
+		allocatorClient.Faults, err = allocator.LoadFaults(faultsFile)
+		if err != nil {
+			return nil, err
+		}
+		klog.Warningf("fault injection from %s is turned on - allocation requests will fail on purpose", faultsFile)
+	}
+	allocatorClient.Limits, err = limitsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	if breakerFailures > 0 {
+		allocatorClient.Breakers = allocator.NewBreakers(allocator.BreakerOptions{
+			Failures:      breakerFailures,
+			ProbeInterval: breakerProbe,
+		})
+		allocatorClient.Breakers.OnStateChange = func(endpoint string, from, to allocator.BreakerState) {
+			klog.Infof("circuit for %s changed from %s to %s", endpoint, from, to)
+		}
+	}
+	if hedgeDelay > 0 {
+		allocatorClient.Hedge, err = hedgeFromFlags()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rerankInterval > 0 {
+		go func() {
+			if err := allocatorClient.RerankEndpoints(context.Background(), rerankOptionsFromFlags()); err != nil {
+				klog.Errorf("could not rerank hosts - %s", err.Error())
+			}
+		}()
+	}
+	if recordFile != "" {
+		f, err := os.OpenFile(recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		allocatorClient.Recorder = allocator.NewRecorder(f)
+	}
+	return allocatorClient, nil
+}
+
+// closeRecorder syncs and closes the --record file, if there is one. Commands defer it, and
+// also call it before klog.Fatal or os.Exit, which skip deferred calls.
+func closeRecorder(allocatorClient *allocator.Client) {
+	if allocatorClient.Recorder == nil {
+		return
+	}
+	if err := allocatorClient.Recorder.Close(); err != nil {
+		klog.Errorf("could not close %s - %s", recordFile, err.Error())
+	}
+}
+
+// tlsOptionsFromFlags builds the TLS options for the allocator client from the flags
+func tlsOptionsFromFlags() (allocator.TLSOptions, error) {
+	minVersion, err := allocator.ParseTLSVersion(tlsMinVersion)
+	if err != nil {
+		return allocator.TLSOptions{}, err
+	}
+	cipherSuites, err := allocator.ParseCipherSuites(tlsCiphers)
+	if err != nil {
+		return allocator.TLSOptions{}, err
+	}
+	return allocator.TLSOptions{
+		ServerName:         tlsServerName,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+		InsecureSkipVerify: insecureSkip,
+	}, nil
+}
+
+// limitsFromFlags builds the client side rate and concurrency limits, or returns nil if none are set
+func limitsFromFlags() (*allocator.Limits, error) {
+	if rateLimit == 0 && maxInFlight == 0 && len(endpointRateLimits) == 0 && len(endpointMaxInFlight) == 0 {
+		return nil, nil
+	}
+	limits := &allocator.Limits{
+		Global:    allocator.Limit{Rate: rateLimit, Burst: rateBurst, MaxInFlight: maxInFlight},
+		Endpoints: make(map[string]allocator.Limit),
+	}
+	for host, value := range endpointRateLimits {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the rate limit for %s - %s", host, err.Error())
+		}
+		limit := limits.Endpoints[host]
+		limit.Rate = rate
+		limit.Burst = rateBurst
+		limits.Endpoints[host] = limit
+	}
+	for host, n := range endpointMaxInFlight {
+		limit := limits.Endpoints[host]
+		limit.MaxInFlight = n
+		limits.Endpoints[host] = limit
+	}
+	return limits, limits.Validate()
+}
+
+// hedgeReleaseTimeout bounds deleting one extra gameserver allocated by a hedged request
+const hedgeReleaseTimeout = 10 * time.Second
+
+// hedgeFromFlags sets up hedged requests. With --hedge-release, each extra gameserver is deleted
+// from the cluster of the host that allocated it, which is usually not the local one.
+func hedgeFromFlags() (*allocator.Hedge, error) {
+	hedge := &allocator.Hedge{Delay: hedgeDelay, Timeout: hedgeTimeout}
+	if !hedgeRelease {
+		return hedge, nil
+	}
+	byContext := map[string]*kube.Client{}
+	kubeClients := map[string]*kube.Client{}
+	for host, kubeCtx := range endpointKubeContext {
+		kubeClient, ok := byContext[kubeCtx]
+		if !ok {
+			var err error
+			kubeClient, err = kube.NewClient(kubeconfig, kubeCtx)
+			if err != nil {
+				return nil, err
+			}
+			byContext[kubeCtx] = kubeClient
+		}
+		kubeClients[hostWithPort(host)] = kubeClient
+	}
+	hedge.Extra = func(allocation *allocator.Allocation) {
+		kubeClient, ok := kubeClients[hostWithPort(allocation.Endpoint)]
+		if !ok {
+			klog.Errorf("could not release %s - no endpoint-kube-context for %s", allocation.GameServerName, allocation.Endpoint)
+			return
+		}
+		klog.Infof("releasing %s, which was also allocated by a hedged request to %s", allocation.GameServerName, allocation.Endpoint)
+		ctx, cancel := context.WithTimeout(context.Background(), hedgeReleaseTimeout)
+		defer cancel()
+		err := kubeClient.DeleteGameServers(ctx, namespace, []string{allocation.GameServerName})
+		if err != nil {
+			klog.Errorf("could not release %s - %s", allocation.GameServerName, err.Error())
+		}
+	}
+	return hedge, nil
+}
+
+// hostWithPort adds the default port to a host without one, so hosts given in different flags match
+func hostWithPort(host string) string {
+	if !strings.Contains(host, ":") {
+		return host + ":443"
+	}
+	return host
+}
+
+// waitForHedges gives hedged requests that lost a race a chance to finish, so that any extra
+// gameserver they allocated is released or logged before the command exits
+func waitForHedges(allocatorClient *allocator.Client) {
+	if allocatorClient.Hedge == nil {
+		return
+	}
+	// The slower request gives up after the hedge timeout, and releasing what it allocated is
+	// bounded too
+	ctx, cancel := context.WithTimeout(context.Background(), hedgeTimeout+hedgeReleaseTimeout)
+	defer cancel()
+	if err := allocatorClient.WaitForHedges(ctx); err != nil {
+		klog.Warningf("gave up waiting for hedged requests - %s", err.Error())
+	}
+}
+
+// rerankOptionsFromFlags returns how often and how eagerly to re-rank the hosts-ping servers
+func rerankOptionsFromFlags() allocator.RerankOptions {
+	return allocator.RerankOptions{
+		Interval:   rerankInterval,
+		Smoothing:  rerankSmoothing,
+		Hysteresis: rerankHysteresis,
+	}
+}
+
+// tokenAuthFromFlags returns the per-request token credentials, or nil if no token is configured
+func tokenAuthFromFlags() credentials.PerRPCCredentials {
+	var source allocator.TokenSource
+	switch {
+	case authToken != "":
+		source = allocator.StaticToken(authToken)
+	case authTokenFile != "":
+		source = &allocator.FileToken{Path: authTokenFile}
+	case authTokenExec != "":
+		// Run it through the shell, so that quoted arguments and paths with spaces work as typed
+		source = &allocator.ExecToken{Command: []string{"sh", "-c", authTokenExec}, CacheFor: authTokenTTL}
+	default:
+		return nil
+	}
+	return &allocator.TokenAuth{
+		Source: source,
+		Header: authHeader,
+		Scheme: authScheme,
+	}
+}
+
+// handleInterrupts cancels on the first SIGINT or SIGTERM. A second signal calls onForce and exits immediately.
+// The returned function stops listening for signals.
+func handleInterrupts(cancel context.CancelFunc, action string, onForce func()) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		klog.Warningf("received interrupt - %s. Interrupt again to exit immediately.", action)
+		cancel()
+		if _, ok := <-signals; !ok {
+			return
+		}
+		klog.Warning("received second interrupt - exiting now")
+		onForce()
+		os.Exit(1)
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
 func fileExists(path string) (bool, error) {
 	if _, err := os.Stat(path); err == nil {
 		return true, nil
@@ -217,37 +743,94 @@ func argsValidator(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("you must specify a namespace")
 	}
 
-	if hosts == nil && pingServers == nil {
-		return fmt.Errorf("you must set either hosts or hosts-ping")
+	if hosts == nil && pingServers == nil && !discoverHost {
+		return fmt.Errorf("you must set either hosts or hosts-ping, or use discover-allocator")
 	}
 
 	if hosts != nil && pingServers != nil {
 		return fmt.Errorf("you cannot set both hosts and hosts-ping")
 	}
 
-	exists, err := fileExists(keyFile)
-	if !exists {
-		return fmt.Errorf("key file %s does not exist", keyFile)
+	if transportName != "grpc" && transportName != "rest" {
+		return fmt.Errorf("transport must be either grpc or rest")
 	}
-	if err != nil {
-		return err
+
+	if certReload > 0 && (clientSecret != "" || caSecret != "") {
+		return fmt.Errorf("cert-reload-interval only works with certificate files, not secrets")
 	}
 
-	exists, err = fileExists(caCertFile)
-	if !exists {
-		return fmt.Errorf("ca cert %s does not exist", caCertFile)
+	tokens := 0
+	for _, value := range []string{authToken, authTokenFile, authTokenExec} {
+		if value != "" {
+			tokens++
+		}
 	}
-	if err != nil {
-		return err
+	if tokens > 1 {
+		return fmt.Errorf("only one of token, token-file and token-exec can be set")
+	}
+
+	// A token can be used instead of a client certificate
+	if clientSecret == "" && !(tokens > 0 && keyFile == "" && certFile == "") {
+		exists, err := fileExists(keyFile)
+		if !exists {
+			return fmt.Errorf("key file %s does not exist", keyFile)
+		}
+		if err != nil {
+			return err
+		}
+
+		exists, err = fileExists(certFile)
+		if !exists {
+			return fmt.Errorf("client cert %s does not exist", certFile)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	exists, err = fileExists(certFile)
-	if !exists {
-		return fmt.Errorf("client cert %s does not exist", certFile)
+	if _, err := tlsOptionsFromFlags(); err != nil {
+		return err
 	}
-	if err != nil {
+	if _, err := limitsFromFlags(); err != nil {
 		return err
 	}
+	if hedgeDelay > 0 && hedgeTimeout <= 0 {
+		return fmt.Errorf("hedge-timeout must be more than zero")
+	}
+	if hedgeRelease {
+		contexts := map[string]bool{}
+		for host := range endpointKubeContext {
+			contexts[hostWithPort(host)] = true
+		}
+		all := append([]string{}, hosts...)
+		for host := range pingServers {
+			all = append(all, host)
+		}
+		for _, host := range all {
+			if !contexts[hostWithPort(host)] {
+				return fmt.Errorf("hedge-release needs an endpoint-kube-context for every host, but %s has none", host)
+			}
+		}
+	}
+	if rerankInterval > 0 {
+		if pingServers == nil {
+			return fmt.Errorf("rerank-interval only works with hosts-ping")
+		}
+		if err := rerankOptionsFromFlags().Validate(); err != nil {
+			return err
+		}
+	}
+
+	// The CA is not needed if the allocator certificate is not verified
+	if caSecret == "" && !(insecureSkip && caCertFile == "") {
+		exists, err := fileExists(caCertFile)
+		if !exists {
+			return fmt.Errorf("ca cert %s does not exist", caCertFile)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
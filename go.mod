@@ -1,14 +1,19 @@
 module github.com/fairwindsops/agones-allocator-client
 
-go 1.14
+go 1.15
 
 require (
 	agones.dev/agones v1.6.0
 	github.com/cenkalti/backoff/v4 v4.1.2
+	github.com/golang/protobuf v1.5.2
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.3.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.8.0
 	google.golang.org/grpc v1.42.0
+	k8s.io/api v0.20.0
+	k8s.io/apimachinery v0.20.0
+	k8s.io/client-go v0.20.0
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	pb "agones.dev/agones/pkg/allocation/go"
@@ -32,8 +33,6 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"k8s.io/klog"
-
-	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
 )
 
 // Client is all of the things necessary to build an allocator request
@@ -45,28 +44,107 @@ type Client struct {
 	// ClientKey is the key corresponding to ClientCert
 	ClientKey []byte
 	// Endpoints is a map of possible allocators and their corresponding pingServers
-	// if there is no ping server for that allocator, then the value is an empty string
+	// if there is no ping server for that allocator, then the value is an empty string.
+	// Use CurrentEndpoints to read it while reranking, since that drops and adds endpoints.
 	Endpoints map[string]string
 	// Namespace is the namespace of the fleet or set of gameservers we wish to allocate from
 	Namespace string
 	// Multicluster is a boolean indicating if a multi-cluster request should be made
 	Multicluster bool
-	// Endpoint is the chose endpoint after checkPing is resolved
+	// Endpoint is the chose endpoint after checkPing is resolved. Use CurrentEndpoint to read it
+	// while requests are in flight, since failover and reranking can change it.
 	Endpoint string
 	// DialOpts is a constructed grpc DialOption that is used to make requests
 	DialOpts grpc.DialOption
+	// TLSConfig is the mTLS configuration that DialOpts is built from. Other transports share it.
+	TLSConfig *tls.Config
+	// TLSOptions are applied on top of the certificates. Change them with ConfigureTLS.
+	TLSOptions TLSOptions
+	// Credentials, if set, are sent with every request, e.g. a TokenAuth for a gateway
+	// that wants a bearer token on top of the client certificate. They are handed to the
+	// Transport with every request.
+	Credentials credentials.PerRPCCredentials
+	// Transport sends the allocation requests. If nil, requests are sent with gRPC using DialOpts.
+	Transport Transport
 	// MatchLabels is a map of key/value pairs to send when asking for an allocation
 	MatchLabels map[string]string
 	// MaxRetries is the maximum number of times to retry allocations
 	MaxRetries int
 	// MetaPatch is metadata to set on the gameserver
 	MetaPatch *pb.MetaPatch
+	// Interceptors wrap every request, including retries, with the first one outermost
+	Interceptors []Interceptor
+	// BeforeAttempt is called before every allocation attempt, if set
+	BeforeAttempt func(AttemptStart)
+	// OnAttempt is called after every allocation attempt, if set
+	OnAttempt func(Attempt)
+	// OnRetry is called when a failed attempt is going to be retried, if set
+	OnRetry func(Retry)
+	// OnEndpointChange is called when the client switches to a different endpoint, if set
+	OnEndpointChange func(from, to string)
+	// Recorder, if set, records every allocation request and its outcome
+	Recorder *Recorder
+	// Faults, if set, inject latency, errors, dropped responses and blackholed endpoints
+	// into every request. They are for testing only.
+	Faults *Faults
+	// Limits, if set, cap the rate of requests and how many are in flight, either for the
+	// whole client or per endpoint
+	Limits *Limits
+	// Breakers, if set, keep a circuit breaker for each endpoint. Requests skip endpoints whose
+	// circuit is open and go to the next one in Endpoints instead.
+	Breakers *Breakers
+	// Hedge, if set, sends the same request to a second endpoint when the first is slow to answer
+	Hedge *Hedge
+	// Concurrency is how many allocations AllocateMany makes at once. Zero makes them one at a time.
+	Concurrency int
+
+	// baseTLSConfig is the certificate configuration before TLSOptions are applied
+	baseTLSConfig *tls.Config
+	// limiters hold the state of Limits, by endpoint, with the global limiter under ""
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+	// hedges tracks the hedged requests that lost a race but are still running
+	hedges sync.WaitGroup
+	// endpointMu guards Endpoint, Endpoints, pingHosts and scores
+	endpointMu sync.RWMutex
+	// pingHosts are all of the endpoints and their ping servers, including the unreachable ones
+	pingHosts map[string]string
+	// scores are the smoothed ping times of the reachable endpoints, by endpoint with its port
+	scores map[string]time.Duration
+}
+
+// Attempt is the outcome of a single allocation request to one endpoint
+type Attempt struct {
+	Endpoint string
+	// Number counts the attempts for one allocation, starting at 1. It is zero for
+	// requests that are never retried, such as benchmarks.
+	Number int
+	// Latency is how long the allocator took, not counting QueueWait
+	Latency time.Duration
+	// QueueWait is how long the request waited in the client for Limits
+	QueueWait time.Duration
+	Err       error
 }
 
 // Allocation is a game server allocation
 type Allocation struct {
-	Address string
-	Port    int32
+	GameServerName string `json:"gameServerName"`
+	NodeName       string `json:"nodeName"`
+	Address        string `json:"address"`
+	// Port is the first port of the gameserver
+	Port int32 `json:"port"`
+	// Ports are all of the gameserver's ports, by name
+	Ports map[string]int32 `json:"ports"`
+	// Endpoint is the allocator that made the allocation
+	Endpoint string `json:"endpoint"`
+	// Attempts is the number of requests it took, including retries
+	Attempts int `json:"attempts"`
+	// Latency is how long the successful request took
+	Latency time.Duration `json:"latency"`
+	// QueueWait is how long the successful request waited in the client for Limits
+	QueueWait time.Duration `json:"queueWait,omitempty"`
+	// Hedged is true if the same request was also sent to a second endpoint
+	Hedged bool `json:"hedged,omitempty"`
 }
 
 // NewClient builds a new client object
@@ -83,7 +161,12 @@ func NewClient(keyFile, certFile, cacertFile, namespace string, multiCluster boo
 	if err != nil {
 		return nil, err
 	}
+	return NewClientFromPEM(key, cert, cacert, namespace, multiCluster, labelSelector, hosts, pingHosts, maxRetries)
+}
 
+// NewClientFromPEM builds a new client object from PEM encoded credentials, such as the
+// ones stored in the Agones allocator secrets
+func NewClientFromPEM(key, cert, cacert []byte, namespace string, multiCluster bool, labelSelector map[string]string, hosts []string, pingHosts map[string]string, maxRetries int) (*Client, error) {
 	newClient := &Client{
 		CA:           cacert,
 		ClientCert:   cert,
@@ -104,15 +187,18 @@ func NewClient(keyFile, certFile, cacertFile, namespace string, multiCluster boo
 		}
 		newClient.Endpoint = hosts[0]
 	} else {
-		newClient.Endpoints = pingHosts
-		err = newClient.setEndpointByPing()
+		// Unreachable endpoints are dropped from Endpoints, which must not change the caller's map
+		for endpoint, pingServer := range pingHosts {
+			newClient.Endpoints[endpoint] = pingServer
+		}
+		err := newClient.setEndpointByPing()
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	klog.V(2).Infof("client endpoint is set to %s", newClient.Endpoint)
-	err = newClient.createRemoteClusterDialOption()
+	err := newClient.createRemoteClusterDialOption()
 	if err != nil {
 		return nil, err
 	}
@@ -121,13 +207,16 @@ func NewClient(keyFile, certFile, cacertFile, namespace string, multiCluster boo
 
 // createRemoteClusterDialOption creates a grpc client dial option with TLS configuration.
 func (c *Client) createRemoteClusterDialOption() error {
-	// Load client cert
-	cert, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
-	if err != nil {
-		return err
+	tlsConfig := &tls.Config{}
+	// Load client cert. It can be left out when the allocator is behind a gateway that
+	// authenticates with Credentials instead.
+	if len(c.ClientCert) != 0 || len(c.ClientKey) != 0 {
+		cert, err := tls.X509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
 	if len(c.CA) != 0 {
 		// Load CA cert, if provided and trust the server certificate.
 		// This is required for self-signed certs.
@@ -136,15 +225,14 @@ func (c *Client) createRemoteClusterDialOption() error {
 			return errors.New("only PEM format is accepted for server CA")
 		}
 	}
-	dialOpts := grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
-	c.DialOpts = dialOpts
+	c.setTLSConfig(tlsConfig)
 
 	return nil
 }
 
-// allocateGameserver allocates a new gamserver
-func (c *Client) allocateGameserver() (*Allocation, error) {
-	request := &pb.AllocationRequest{
+// newAllocationRequest builds the allocation request for the client's settings
+func (c *Client) newAllocationRequest() *pb.AllocationRequest {
+	return &pb.AllocationRequest{
 		Namespace: c.Namespace,
 		MultiClusterSetting: &pb.MultiClusterSetting{
 			Enabled: c.Multicluster,
@@ -152,23 +240,95 @@ func (c *Client) allocateGameserver() (*Allocation, error) {
 		RequiredGameServerSelector: &pb.LabelSelector{
 			MatchLabels: c.MatchLabels,
 		},
-		MetaPatch: c.MetaPatch,
+		MetaPatch: copyMetaPatch(c.MetaPatch),
 	}
+}
 
-	resp, err := c.makeRequest(request)
+// allocateGameserver allocates a new gamserver from the endpoint. probe is the probeID the endpoint's
+// circuit breaker gave the request, if any. number counts the attempts, starting at 1.
+// onAttempt, if set, is called after the attempt along with c.OnAttempt.
+func (c *Client) allocateGameserver(ctx context.Context, endpoint string, probe probeID, number int, onAttempt func(Attempt)) (*Allocation, error) {
+	if c.BeforeAttempt != nil {
+		c.BeforeAttempt(AttemptStart{Endpoint: endpoint, Number: number})
+	}
+	release, queueWait, err := c.waitForLimits(ctx, endpoint)
 	if err != nil {
+		if c.Breakers != nil {
+			c.Breakers.release(endpoint, probe)
+		}
 		return nil, err
 	}
+	start := time.Now()
+	resp, err := c.makeRequest(ctx, endpoint, c.newAllocationRequest())
+	latency := time.Since(start)
+	release()
+	if c.Breakers != nil {
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the endpoint
+			c.Breakers.release(endpoint, probe)
+		} else {
+			c.Breakers.record(endpoint, probe, err)
+		}
+	}
+	attempt := Attempt{
+		Endpoint:  endpoint,
+		Number:    number,
+		Latency:   latency,
+		QueueWait: queueWait,
+		Err:       err,
+	}
+	if onAttempt != nil {
+		onAttempt(attempt)
+	}
+	if c.OnAttempt != nil {
+		c.OnAttempt(attempt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := newAllocation(resp)
+	if err != nil {
+		return nil, err
+	}
+	allocation.Endpoint = endpoint
+	allocation.Latency = latency
+	allocation.QueueWait = queueWait
+	allocation.Attempts = 1
+	return allocation, nil
+}
 
+// newAllocation converts an allocator response into an Allocation
+func newAllocation(resp *pb.AllocationResponse) (*Allocation, error) {
+	if len(resp.Ports) < 1 {
+		return nil, fmt.Errorf("gameserver %s was allocated without any ports", resp.GameServerName)
+	}
 	allocation := &Allocation{
-		Address: resp.Address,
-		Port:    resp.Ports[0].Port,
+		GameServerName: resp.GameServerName,
+		NodeName:       resp.NodeName,
+		Address:        resp.Address,
+		Port:           resp.Ports[0].Port,
+		Ports:          make(map[string]int32),
+	}
+	for _, port := range resp.Ports {
+		allocation.Ports[port.Name] = port.Port
 	}
 	return allocation, nil
 }
 
 // AllocateGameserverWithRetry will retry multiple times
 func (c *Client) AllocateGameserverWithRetry() (*Allocation, error) {
+	return c.AllocateGameserverWithRetryContext(context.Background())
+}
+
+// AllocateGameserverWithRetryContext will retry multiple times, giving up
+// early if the context is cancelled
+func (c *Client) AllocateGameserverWithRetryContext(ctx context.Context) (*Allocation, error) {
+	return c.allocateWithRetry(ctx, nil)
+}
+
+// allocateWithRetry is AllocateGameserverWithRetryContext, with onAttempt called after every
+// attempt of this allocation only
+func (c *Client) allocateWithRetry(ctx context.Context, onAttempt func(Attempt)) (*Allocation, error) {
 	var a *Allocation
 	var err error
 
@@ -176,11 +336,24 @@ func (c *Client) AllocateGameserverWithRetry() (*Allocation, error) {
 	b.InitialInterval = time.Duration(1 * time.Second)
 
 	i := 0
+	failed := ""
 	for {
 
 		delay := b.NextBackOff()
-		a, err = c.allocateGameserver()
+		var endpoint string
+		var probe probeID
+		endpoint, probe, err = c.pickEndpoint(failed)
+		if err == nil {
+			if c.Hedge != nil {
+				a, err = c.allocateHedged(ctx, endpoint, probe, i+1, onAttempt)
+			} else {
+				a, err = c.allocateGameserver(ctx, endpoint, probe, i+1, onAttempt)
+			}
+		}
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			klog.V(2).Info(err.Error())
 			if c.MaxRetries == 0 {
 				return nil, fmt.Errorf("%s - max-retries is zero", err.Error())
@@ -190,34 +363,58 @@ func (c *Client) AllocateGameserverWithRetry() (*Allocation, error) {
 			}
 			i++
 			klog.V(2).Infof("retrying in %fs - %d retries left", delay.Seconds(), c.MaxRetries-i)
+			if c.OnRetry != nil {
+				c.OnRetry(Retry{Number: i + 1, Delay: delay, Err: err})
+			}
 
-			if len(c.Endpoints) > 1 {
-				for ep := range c.Endpoints {
-					if ep != c.Endpoint {
-						klog.V(2).Infof("trying a different allocator this time: %s", ep)
-						c.setEndpoint(ep)
-						break
-					}
-				}
+			failed = endpoint
+			// With breakers, the next attempt picks its own endpoint, and the current one is
+			// kept so that traffic returns to it once it recovers
+			if c.Breakers == nil {
+				c.failover(endpoint)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
 			}
-			time.Sleep(delay)
 			continue
 		} else {
 			break
 		}
 	}
+	a.Attempts = i + 1
 	return a, nil
 }
 
-func (c *Client) makeRequest(request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
-	conn, err := grpc.Dial(c.Endpoint, c.DialOpts)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+// makeRequest sends the request through the interceptors to the endpoint, and records it
+func (c *Client) makeRequest(ctx context.Context, endpoint string, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	return c.intercept(ctx, endpoint, request, func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		if c.Recorder == nil {
+			return c.sendRequest(ctx, endpoint, request)
+		}
+		start := time.Now()
+		response, err := c.sendRequest(ctx, endpoint, request)
+		c.Recorder.record(start, endpoint, request, response, err)
+		return response, err
+	})
+}
 
-	grpcClient := pb.NewAllocationServiceClient(conn)
-	response, err := grpcClient.Allocate(context.Background(), request)
+func (c *Client) sendRequest(ctx context.Context, endpoint string, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = NewGRPCTransport(c.DialOpts)
+	}
+	send := func() (*pb.AllocationResponse, error) {
+		return transport.Allocate(ctx, endpoint, request, c.Credentials)
+	}
+	var response *pb.AllocationResponse
+	var err error
+	if c.Faults != nil {
+		response, err = c.Faults.apply(ctx, endpoint, send)
+	} else {
+		response, err = send()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -229,36 +426,37 @@ func (c *Client) makeRequest(request *pb.AllocationRequest) (*pb.AllocationRespo
 // setEndpoint picks a host from the list that has the lowest ping time
 // if checkPing is false, then endpoint is set to the first host in the list
 func (c *Client) setEndpointByPing() error {
-	traces := []ping.Trace{}
-	for server, pingServer := range c.Endpoints {
-		klog.V(2).Infof("checking ping for server: %s ping: %s", server, pingServer)
-		trace := ping.Trace{
-			Host: pingServer,
+	c.endpointMu.Lock()
+	if c.pingHosts == nil {
+		// Keep every ping server, so that endpoints dropped now can come back when reranking
+		c.pingHosts = make(map[string]string, len(c.Endpoints))
+		for endpoint, pingServer := range c.Endpoints {
+			c.pingHosts[endpoint] = pingServer
 		}
-		err := trace.Run()
-		if err != nil {
-			klog.V(3).Infof("trace failed on %s - %s", pingServer, err.Error())
-			delete(c.Endpoints, server) // Remove the endpoint from the possible list since it is not reachable
-			continue
-		}
-		traces = append(traces, trace)
 	}
-	if len(traces) < 1 {
+	pingHosts := c.pingHosts
+	c.endpointMu.Unlock()
+
+	times := measurePings(pingHosts)
+	if len(times) < 1 {
 		return fmt.Errorf("no traces succeeded, could not find a valid server")
 	}
-	fastest, err := ping.FastestTrace(traces)
-	if err != nil {
-		return err
-	}
-	for host, pingServer := range c.Endpoints {
-		if strings.Contains(fastest.Host, pingServer) {
-			klog.V(2).Infof("setting fastest endpoint to %s", host)
-			c.setEndpoint(host)
-			return nil
+
+	c.endpointMu.Lock()
+	c.scores = make(map[string]time.Duration, len(times))
+	for endpoint := range pingHosts {
+		if _, ok := times[endpoint]; !ok {
+			delete(c.Endpoints, endpoint) // Remove the endpoint from the possible list since it is not reachable
+			continue
 		}
+		c.scores[withDefaultPort(endpoint)] = times[endpoint]
 	}
+	fastest := c.fastest()
+	c.endpointMu.Unlock()
 
-	return fmt.Errorf("unknown error resolving hosts")
+	klog.V(2).Infof("setting fastest endpoint to %s", fastest)
+	c.setEndpoint(fastest)
+	return nil
 }
 
 func isIPV4(ip string) bool {
@@ -282,8 +480,56 @@ func isIPV4(ip string) bool {
 func (c *Client) setEndpoint(endpoint string) {
 	if !strings.Contains(endpoint, ":") {
 		klog.V(2).Infof("no port in endpoint %s - assuming 443", endpoint)
-		c.Endpoint = fmt.Sprintf("%s:443", endpoint)
-		return
+		endpoint = withDefaultPort(endpoint)
 	}
+	c.endpointMu.Lock()
+	previous := c.Endpoint
 	c.Endpoint = endpoint
+	c.endpointMu.Unlock()
+	if c.OnEndpointChange != nil && previous != endpoint {
+		c.OnEndpointChange(previous, endpoint)
+	}
+}
+
+// CurrentEndpoint returns the endpoint that requests are sent to first
+func (c *Client) CurrentEndpoint() string {
+	c.endpointMu.RLock()
+	defer c.endpointMu.RUnlock()
+	return c.Endpoint
+}
+
+// CurrentEndpoints returns a copy of Endpoints that is safe to read while reranking changes it
+func (c *Client) CurrentEndpoints() map[string]string {
+	c.endpointMu.RLock()
+	defer c.endpointMu.RUnlock()
+	endpoints := make(map[string]string, len(c.Endpoints))
+	for endpoint, pingServer := range c.Endpoints {
+		endpoints[endpoint] = pingServer
+	}
+	return endpoints
+}
+
+// failover switches to the best endpoint other than the one that failed, if there is one.
+// Concurrent requests often fail on the same endpoint, so only the first one to fail over
+// switches. The others find that the current endpoint has already changed and keep it.
+func (c *Client) failover(failed string) {
+	failed = withDefaultPort(failed)
+	for _, endpoint := range c.rankedEndpoints() {
+		if endpoint == failed {
+			continue
+		}
+		c.endpointMu.Lock()
+		previous := c.Endpoint
+		if withDefaultPort(previous) != failed {
+			c.endpointMu.Unlock()
+			return
+		}
+		c.Endpoint = endpoint
+		c.endpointMu.Unlock()
+		klog.V(2).Infof("trying a different allocator this time: %s", endpoint)
+		if c.OnEndpointChange != nil {
+			c.OnEndpointChange(previous, endpoint)
+		}
+		return
+	}
 }
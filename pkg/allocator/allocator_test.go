@@ -17,9 +17,17 @@ limitations under the License
 package allocator
 
 import (
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	pb "agones.dev/agones/pkg/allocation/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
 )
 
 func Test_isIPV4(t *testing.T) {
@@ -92,3 +100,144 @@ func TestClient_setEndpointByPing(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_setEndpointByPing_latency(t *testing.T) {
+	near := httptest.NewServer(ping.NewServer(ping.Faults{Latency: 10 * time.Millisecond}))
+	defer near.Close()
+	far := httptest.NewServer(ping.NewServer(ping.Faults{Latency: 100 * time.Millisecond}))
+	defer far.Close()
+	lost := httptest.NewServer(ping.NewServer(ping.Faults{Loss: 1}))
+	defer lost.Close()
+
+	c := &Client{
+		Endpoints: map[string]string{
+			"near.example.com:443": near.URL,
+			"far.example.com:443":  far.URL,
+			"lost.example.com:443": lost.URL,
+		},
+	}
+	require.NoError(t, c.setEndpointByPing())
+	assert.Equal(t, "near.example.com:443", c.Endpoint)
+	assert.NotContains(t, c.Endpoints, "lost.example.com:443", "unreachable endpoints are dropped")
+	assert.Contains(t, c.Endpoints, "far.example.com:443")
+}
+
+func TestNewClientFromPEM_pingHosts(t *testing.T) {
+	near := httptest.NewServer(ping.NewServer(ping.Faults{}))
+	defer near.Close()
+	lost := httptest.NewServer(ping.NewServer(ping.Faults{Loss: 1}))
+	defer lost.Close()
+	certs, err := allocatortest.NewCertificates()
+	require.NoError(t, err)
+
+	pingHosts := map[string]string{"near.example.com:443": near.URL, "lost.example.com:443": lost.URL}
+	c, err := NewClientFromPEM(certs.ClientKey, certs.ClientCert, certs.CA, "default", false, nil, nil, pingHosts, 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"near.example.com:443": near.URL}, c.CurrentEndpoints())
+	assert.Len(t, pingHosts, 2, "the caller's map is not changed")
+}
+
+func newFakeAllocator(t *testing.T, certs *allocatortest.Certificates) *allocatortest.Server {
+	server, err := allocatortest.NewServer(allocatortest.Options{Certificates: certs})
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeClient returns a client for the fake allocators, which must share certificates. The
+// first one is the current endpoint.
+func newFakeClient(t *testing.T, servers ...*allocatortest.Server) *Client {
+	certs := servers[0].Certificates
+	hosts := make([]string, 0, len(servers))
+	for _, server := range servers {
+		hosts = append(hosts, server.Address)
+	}
+	c, err := NewClientFromPEM(certs.ClientKey, certs.ClientCert, certs.CA, "default", false, nil, hosts, nil, 0)
+	require.NoError(t, err)
+	return c
+}
+
+// newFailoverPair starts a primary and a secondary fake allocator that both have the
+// gameservers, and returns them with a client that prefers the primary
+func newFailoverPair(t *testing.T, gameServers ...allocatortest.GameServer) (primary, secondary *allocatortest.Server, c *Client) {
+	certs, err := allocatortest.NewCertificates()
+	require.NoError(t, err)
+	primary = newFakeAllocator(t, certs)
+	secondary = newFakeAllocator(t, certs)
+	primary.AddGameServers(gameServers...)
+	secondary.AddGameServers(gameServers...)
+	return primary, secondary, newFakeClient(t, primary, secondary)
+}
+
+// readyGameServers returns a gameserver listening on 10.0.0.1:7000 for each name
+func readyGameServers(names ...string) []allocatortest.GameServer {
+	gameServers := make([]allocatortest.GameServer, 0, len(names))
+	for _, name := range names {
+		gameServers = append(gameServers, allocatortest.GameServer{Name: name, Address: "10.0.0.1", Ports: []allocatortest.Port{{Name: "default", Port: 7000}}})
+	}
+	return gameServers
+}
+
+func TestClient_AllocateGameserverWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   int
+		primary      []allocatortest.Behavior
+		wantErr      bool
+		wantAttempts int
+		wantFailover bool
+	}{
+		{
+			name:         "first try",
+			maxRetries:   2,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry on the other allocator",
+			maxRetries:   2,
+			primary:      []allocatortest.Behavior{allocatortest.Fail(codes.Unavailable, "down")},
+			wantAttempts: 2,
+			wantFailover: true,
+		},
+		{
+			name:       "max-retries zero",
+			maxRetries: 0,
+			primary:    []allocatortest.Behavior{allocatortest.Fail(codes.Unavailable, "down")},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary, c := newFailoverPair(t,
+				allocatortest.GameServer{Name: "gs-other", Labels: map[string]string{"mode": "casual"}, Address: "10.0.0.1"},
+				allocatortest.GameServer{Name: "gs-ranked", Labels: map[string]string{"mode": "ranked"}, Address: "10.0.0.2", Ports: []allocatortest.Port{{Name: "game", Port: 7654}}},
+			)
+			primary.Script(tt.primary...)
+			c.MatchLabels = map[string]string{"mode": "ranked"}
+			c.MaxRetries = tt.maxRetries
+			c.MetaPatch = &pb.MetaPatch{Labels: map[string]string{"player": "one"}}
+
+			allocation, err := c.AllocateGameserverWithRetry()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "gs-ranked", allocation.GameServerName)
+			assert.Equal(t, "10.0.0.2", allocation.Address)
+			assert.Equal(t, int32(7654), allocation.Port)
+			assert.Equal(t, map[string]int32{"game": 7654}, allocation.Ports)
+			assert.Equal(t, tt.wantAttempts, allocation.Attempts)
+
+			served := primary
+			if tt.wantFailover {
+				served = secondary
+			}
+			assert.Equal(t, served.Address, allocation.Endpoint)
+			request := served.Requests()[0].Request
+			assert.Equal(t, "default", request.Namespace)
+			assert.Equal(t, map[string]string{"mode": "ranked"}, request.RequiredGameServerSelector.MatchLabels)
+			assert.Equal(t, "one", served.GameServers()[1].Labels["player"])
+		})
+	}
+}
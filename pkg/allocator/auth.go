@@ -0,0 +1,172 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultTokenHeader is the header tokens are sent in unless another one is set
+const DefaultTokenHeader = "authorization"
+
+// TokenSource provides the token sent with every allocation request
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token that never changes
+type StaticToken string
+
+// Token returns the token
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// FileToken reads the token from a file, and reads it again whenever the file changes
+type FileToken struct {
+	Path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+// Token returns the contents of the file, with surrounding whitespace removed
+func (t *FileToken) Token(ctx context.Context) (string, error) {
+	info, err := os.Stat(t.Path)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && info.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+	data, err := ioutil.ReadFile(t.Path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", t.Path)
+	}
+	t.token = token
+	t.modTime = info.ModTime()
+	return t.token, nil
+}
+
+// ExecToken gets the token from the output of a command. The command can print either the
+// token itself, or a JSON object like {"token": "...", "expiry": "2020-01-01T00:00:00Z"}.
+type ExecToken struct {
+	Command []string
+	// CacheFor is how long to reuse a token that has no expiry. Zero runs the command for every request.
+	CacheFor time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// execTokenOutput is the JSON form of the command output
+type execTokenOutput struct {
+	Token  string    `json:"token"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// Token returns the cached token, or runs the command if there is no valid one
+func (t *ExecToken) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	if len(t.Command) == 0 {
+		return "", fmt.Errorf("no token command set")
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command %s failed: %s %s", t.Command[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	output := execTokenOutput{}
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &output); err != nil {
+			return "", fmt.Errorf("could not decode token command output - %s", err.Error())
+		}
+	} else {
+		output.Token = string(trimmed)
+	}
+	if output.Token == "" {
+		return "", fmt.Errorf("token command %s returned an empty token", t.Command[0])
+	}
+
+	t.token = output.Token
+	t.expires = time.Now().Add(t.CacheFor)
+	if !output.Expiry.IsZero() {
+		t.expires = output.Expiry
+	}
+	return t.token, nil
+}
+
+// TokenAuth sends a token from Source with every request, in gRPC metadata or an HTTP header
+// depending on the transport. It can also be used directly as gRPC per-RPC credentials.
+type TokenAuth struct {
+	Source TokenSource
+	// Header is the metadata key to send. Defaults to authorization.
+	Header string
+	// Scheme is put in front of the token, e.g. Bearer. Empty sends the token on its own, as for an API key.
+	Scheme string
+}
+
+// GetRequestMetadata returns the header and token
+func (a *TokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := a.Source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := a.Header
+	if header == "" {
+		header = DefaultTokenHeader
+	}
+	if a.Scheme != "" {
+		token = fmt.Sprintf("%s %s", a.Scheme, token)
+	}
+	return map[string]string{strings.ToLower(header): token}, nil
+}
+
+// RequireTransportSecurity is true, since tokens should never be sent in the clear
+func (a *TokenAuth) RequireTransportSecurity() bool {
+	return true
+}
+
+var _ credentials.PerRPCCredentials = &TokenAuth{}
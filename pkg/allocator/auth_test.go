@@ -0,0 +1,171 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+
+	source := &FileToken{Path: path}
+	token, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "first", token)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	// Make sure the change is visible even on file systems with coarse timestamps
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	token, err = source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+
+	require.NoError(t, os.Remove(path))
+	_, err = source.Token(context.Background())
+	assert.Error(t, err)
+}
+
+func TestExecToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", command: []string{"sh", "-c", "echo plain-token"}, want: "plain-token"},
+		{name: "json", command: []string{"sh", "-c", `echo '{"token": "json-token", "expiry": "2100-01-01T00:00:00Z"}'`}, want: "json-token"},
+		{name: "empty", command: []string{"sh", "-c", "true"}, wantErr: true},
+		{name: "fails", command: []string{"sh", "-c", "echo nope >&2; exit 1"}, wantErr: true},
+		{name: "no command", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &ExecToken{Command: tt.command}
+			got, err := source.Token(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTokenAuth_GetRequestMetadata(t *testing.T) {
+	auth := &TokenAuth{Source: StaticToken("abc"), Scheme: "Bearer"}
+	md, err := auth.GetRequestMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer abc"}, md)
+
+	auth = &TokenAuth{Source: StaticToken("abc"), Header: "X-API-Key"}
+	md, err = auth.GetRequestMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"x-api-key": "abc"}, md)
+}
+
+func TestClient_Credentials_REST(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&pb.AllocationResponse{GameServerName: "gs-1"})
+	}))
+	defer server.Close()
+
+	c := &Client{
+		Transport: NewRESTTransport(server.Client().Transport.(*http.Transport).TLSClientConfig),
+	}
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+
+	_, err := c.sendRequest(context.Background(), endpoint, &pb.AllocationRequest{})
+	assert.Error(t, err)
+
+	c.Credentials = &TokenAuth{Source: StaticToken("secret"), Scheme: "Bearer"}
+	response, err := c.sendRequest(context.Background(), endpoint, &pb.AllocationRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "gs-1", response.GameServerName)
+}
+
+func TestClient_Credentials_gRPC(t *testing.T) {
+	allocatorServer := newFakeAllocator(t, nil)
+	allocatorServer.AddGameServers(allocatortest.GameServer{Name: "gs-1", Address: "10.0.0.1"})
+	c := newFakeClient(t, allocatorServer)
+	c.Credentials = &TokenAuth{Source: StaticToken("secret"), Scheme: "Bearer"}
+
+	_, err := c.sendRequest(context.Background(), allocatorServer.Address, &pb.AllocationRequest{Namespace: "default"})
+	require.NoError(t, err)
+	requests := allocatorServer.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, []string{"Bearer secret"}, requests[0].Metadata.Get("authorization"))
+
+	// gRPC refuses to send the token over a connection without transport security
+	plaintext, err := allocatortest.NewServer(allocatortest.Options{Insecure: true})
+	require.NoError(t, err)
+	defer plaintext.Close()
+	c.DialOpts = grpc.WithInsecure()
+	_, err = c.sendRequest(context.Background(), plaintext.Address, &pb.AllocationRequest{Namespace: "default"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "insecure connection")
+	}
+	assert.Empty(t, plaintext.Requests())
+
+	c.Credentials = nil
+	_, err = c.sendRequest(context.Background(), plaintext.Address, &pb.AllocationRequest{Namespace: "default"})
+	assert.Error(t, err, "the fleet is empty")
+	require.Len(t, plaintext.Requests(), 1, "requests without a token are still sent")
+}
+
+// credentialsTransport records the credentials it is given
+type credentialsTransport struct {
+	creds credentials.PerRPCCredentials
+}
+
+func (t *credentialsTransport) Allocate(ctx context.Context, endpoint string, request *pb.AllocationRequest, creds credentials.PerRPCCredentials) (*pb.AllocationResponse, error) {
+	t.creds = creds
+	return &pb.AllocationResponse{GameServerName: "gs-1"}, nil
+}
+
+func TestClient_Credentials_customTransport(t *testing.T) {
+	transport := &credentialsTransport{}
+	auth := &TokenAuth{Source: StaticToken("secret")}
+	c := &Client{Transport: transport, Credentials: auth}
+
+	_, err := c.sendRequest(context.Background(), "east:443", &pb.AllocationRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, auth, transport.creds)
+}
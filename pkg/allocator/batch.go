@@ -0,0 +1,63 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"sync"
+)
+
+// AllocationResult is the outcome of one allocation in a batch
+type AllocationResult struct {
+	Allocation *Allocation
+	Err        error
+}
+
+// AllocateMany allocates n gameservers with the client's settings, at most Concurrency at a
+// time, each with the usual retries. The results are in request order, with an error for each
+// allocation that failed. Once the context is cancelled no more allocations are started, and
+// the ones that were never started fail with the context's error.
+func (c *Client) AllocateMany(ctx context.Context, n int) []AllocationResult {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]AllocationResult, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			for j := i; j < n; j++ {
+				results[j].Err = ctx.Err()
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			allocation, err := c.AllocateGameserverWithRetryContext(ctx)
+			results[i] = AllocationResult{Allocation: allocation, Err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
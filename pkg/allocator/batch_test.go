@@ -0,0 +1,110 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestClient_AllocateMany(t *testing.T) {
+	var requests, inFlight, maxInFlight int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+		if n%3 == 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"code":8,"message":"no gameservers"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&pb.AllocationResponse{
+			GameServerName: fmt.Sprintf("gs-%d", n),
+			Address:        "10.0.0.1",
+			Ports:          []*pb.AllocationResponse_GameServerStatusPort{{Name: "default", Port: 7000 + n}},
+		})
+	}))
+	defer server.Close()
+
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+	c := &Client{
+		Endpoint:    endpoint,
+		Endpoints:   map[string]string{endpoint: ""},
+		Transport:   NewRESTTransport(server.Client().Transport.(*http.Transport).TLSClientConfig),
+		Concurrency: 2,
+	}
+
+	results := c.AllocateMany(context.Background(), 6)
+	assert.Len(t, results, 6)
+	failed := 0
+	names := map[string]bool{}
+	for _, result := range results {
+		if result.Err != nil {
+			assert.Nil(t, result.Allocation)
+			failed++
+			continue
+		}
+		assert.Equal(t, endpoint, result.Allocation.Endpoint)
+		names[result.Allocation.GameServerName] = true
+	}
+	assert.Equal(t, 2, failed)
+	assert.Len(t, names, 4, "every allocation should be a different gameserver")
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Concurrency = 0
+	results = c.AllocateMany(ctx, 3)
+	for _, result := range results {
+		assert.Equal(t, context.Canceled, result.Err)
+	}
+}
+
+func TestClient_AllocateMany_failover(t *testing.T) {
+	primary, secondary, c := newFailoverPair(t, readyGameServers("gs-1", "gs-2", "gs-3", "gs-4")...)
+	primary.SetDefault(allocatortest.Fail(codes.Unavailable, "down"))
+	c.MaxRetries = 3
+	var changes int32
+	c.OnEndpointChange = func(from, to string) { atomic.AddInt32(&changes, 1) }
+	c.Concurrency = 4
+
+	// Every allocation fails over at the same time, which -race checks, but only one switches
+	for _, result := range c.AllocateMany(context.Background(), 4) {
+		require.NoError(t, result.Err)
+	}
+	assert.Equal(t, secondary.Address, c.CurrentEndpoint())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&changes))
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// BenchOptions configures an allocation benchmark
+type BenchOptions struct {
+	// Requests is the total number of allocations to request. Zero means no limit, so Duration must be set.
+	Requests int
+	// Duration is how long to send requests for. Zero means until Requests have been sent.
+	Duration time.Duration
+	// QPS is the target request rate. If zero, requests are sent back to back.
+	QPS float64
+	// Concurrency is the maximum number of requests in flight
+	Concurrency int
+}
+
+// BenchSummary is the result of an allocation benchmark
+type BenchSummary struct {
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Requests  int             `json:"requests"`
+	Succeeded int             `json:"succeeded"`
+	Codes     map[string]int  `json:"codes"`
+	Latencies []time.Duration `json:"latencies"`
+	// QueueWaits are how long each request waited in the client for its Limits, in the same
+	// order as Latencies
+	QueueWaits []time.Duration `json:"queueWaits,omitempty"`
+	// GameServers is the list of gameservers that were allocated
+	GameServers []string `json:"gameServers"`
+	// Namespaces are the namespaces the gameservers were allocated in, in the same order as GameServers
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// GameServersByNamespace returns the allocated gameservers, by namespace. Requests without a
+// namespace allocate in the allocator's default namespace, "default".
+func (s BenchSummary) GameServersByNamespace() map[string][]string {
+	byNamespace := map[string][]string{}
+	for i, name := range s.GameServers {
+		namespace := "default"
+		if i < len(s.Namespaces) && s.Namespaces[i] != "" {
+			namespace = s.Namespaces[i]
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], name)
+	}
+	return byNamespace
+}
+
+// BenchReport collects the results of an allocation benchmark. It is safe to read while the benchmark is running.
+type BenchReport struct {
+	mu      sync.Mutex
+	summary BenchSummary
+}
+
+// NewBenchReport returns an empty report with the start time set
+func NewBenchReport() *BenchReport {
+	return &BenchReport{
+		summary: BenchSummary{
+			Start: time.Now(),
+			Codes: make(map[string]int),
+		},
+	}
+}
+
+func (r *BenchReport) record(latency, queueWait time.Duration, namespace, gameServer string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Requests++
+	r.summary.Codes[status.Code(err).String()]++
+	r.summary.Latencies = append(r.summary.Latencies, latency)
+	r.summary.QueueWaits = append(r.summary.QueueWaits, queueWait)
+	if err == nil {
+		r.summary.Succeeded++
+		r.summary.GameServers = append(r.summary.GameServers, gameServer)
+		r.summary.Namespaces = append(r.summary.Namespaces, namespace)
+	}
+}
+
+func (r *BenchReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.End = time.Now()
+}
+
+// Summary returns a copy of the results as they currently stand
+func (r *BenchReport) Summary() BenchSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.summary
+	summary.Codes = make(map[string]int, len(r.summary.Codes))
+	for code, count := range r.summary.Codes {
+		summary.Codes[code] = count
+	}
+	summary.Latencies = append([]time.Duration(nil), r.summary.Latencies...)
+	summary.QueueWaits = append([]time.Duration(nil), r.summary.QueueWaits...)
+	summary.GameServers = append([]string(nil), r.summary.GameServers...)
+	summary.Namespaces = append([]string(nil), r.summary.Namespaces...)
+	if summary.End.IsZero() {
+		summary.End = time.Now()
+	}
+	return summary
+}
+
+// Print writes a human readable summary of the benchmark
+func (s BenchSummary) Print(w io.Writer) {
+	elapsed := s.End.Sub(s.Start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(s.Requests) / elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "Sent %d allocation requests in %s (%.2f/s), %d succeeded\n", s.Requests, elapsed.Round(time.Millisecond), rate, s.Succeeded)
+
+	latencies := sortedDurations(s.Latencies)
+	fmt.Fprintf(w, "Latency p50: %s p90: %s p99: %s max: %s\n",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+	queueWaits := sortedDurations(s.QueueWaits)
+	if percentile(queueWaits, 100) > 0 {
+		fmt.Fprintf(w, "Queue wait p50: %s p90: %s p99: %s max: %s\n",
+			percentile(queueWaits, 50), percentile(queueWaits, 90), percentile(queueWaits, 99), percentile(queueWaits, 100))
+	}
+
+	fmt.Fprintln(w, "Response codes:")
+	for _, code := range sortedKeys(s.Codes) {
+		fmt.Fprintf(w, "  %-20s %d\n", code, s.Codes[code])
+	}
+}
+
+// RunBench sends allocation requests as fast as the options allow, without connecting to the
+// allocated gameservers. Each request is made once, without retries, so that the allocator's
+// own latency and error codes are recorded. Cancelling the context stops the benchmark.
+func (c *Client) RunBench(ctx context.Context, opts BenchOptions, report *BenchReport) error {
+	if opts.Requests <= 0 && opts.Duration <= 0 {
+		return fmt.Errorf("you must set a number of requests or a duration")
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	// Requests already in flight when the duration runs out are allowed to finish,
+	// so that any gameservers they allocate are still recorded.
+	stop := ctx
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		stop, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				c.benchRequest(ctx, c.newAllocationRequest(), report)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if opts.QPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.QPS))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for sent := 0; opts.Requests <= 0 || sent < opts.Requests; sent++ {
+		if tick != nil {
+			select {
+			case <-stop.Done():
+			case <-tick:
+			}
+		}
+		if stop.Err() != nil {
+			break
+		}
+		select {
+		case <-stop.Done():
+		case jobs <- struct{}{}:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	report.finish()
+	return nil
+}
+
+func (c *Client) benchRequest(ctx context.Context, request *pb.AllocationRequest, report *BenchReport) {
+	endpoint := c.CurrentEndpoint()
+	if c.BeforeAttempt != nil {
+		c.BeforeAttempt(AttemptStart{Endpoint: endpoint})
+	}
+	release, queueWait, err := c.waitForLimits(ctx, endpoint)
+	if err != nil {
+		// Only a cancelled benchmark stops a request from getting through the limits
+		return
+	}
+	start := time.Now()
+	resp, err := c.makeRequest(ctx, endpoint, request)
+	latency := time.Since(start)
+	release()
+	if c.OnAttempt != nil {
+		c.OnAttempt(Attempt{Endpoint: endpoint, Latency: latency, QueueWait: queueWait, Err: err})
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The benchmark was cancelled while this request was in flight
+			return
+		}
+		klog.V(3).Infof("allocation failed after %s - %s", latency, err.Error())
+		report.record(latency, queueWait, request.Namespace, "", err)
+		return
+	}
+	klog.V(3).Infof("allocated %s in %s", resp.GameServerName, latency)
+	report.record(latency, queueWait, request.Namespace, resp.GameServerName, nil)
+}
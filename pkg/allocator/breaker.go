@@ -0,0 +1,296 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// BreakerState is the state of the circuit breaker for one endpoint
+type BreakerState string
+
+const (
+	// BreakerClosed means the endpoint is healthy and gets traffic as usual
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen means the endpoint failed too many times in a row, and traffic goes to other endpoints
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen means the probe interval has passed, and one request at a time is let
+	// through to see whether the endpoint has recovered
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+const (
+	// DefaultBreakerFailures is how many failures in a row open the circuit by default
+	DefaultBreakerFailures = 5
+	// DefaultBreakerProbeInterval is how long an open circuit waits before probing by default
+	DefaultBreakerProbeInterval = 10 * time.Second
+)
+
+// BreakerOptions configure the circuit breakers
+type BreakerOptions struct {
+	// Failures is how many failures in a row open the circuit. Defaults to DefaultBreakerFailures.
+	Failures int
+	// ProbeInterval is how long an open circuit waits before letting a probe request through.
+	// Defaults to DefaultBreakerProbeInterval.
+	ProbeInterval time.Duration
+	// Successes is how many probes in a row have to succeed to close the circuit again. Defaults to 1.
+	Successes int
+	// IsFailure decides which errors count against an endpoint. By default, the errors that
+	// mean the allocator itself is in trouble do: Unavailable, DeadlineExceeded, Internal and Unknown.
+	IsFailure func(error) bool
+}
+
+// BreakerStatus is the state of one endpoint's circuit breaker, and counters for it
+type BreakerStatus struct {
+	State BreakerState `json:"state"`
+	// Since is when the breaker entered its current state
+	Since time.Time `json:"since"`
+	// Failures is the number of failures in a row
+	Failures int `json:"failures"`
+	// Opened counts how many times the circuit has opened
+	Opened int `json:"opened"`
+	// Rejected counts the requests that went to another endpoint, or failed, because the circuit was open
+	Rejected int `json:"rejected"`
+}
+
+// Breakers keep a circuit breaker for each allocator endpoint. When an endpoint fails
+// too many times in a row its circuit opens, and requests go to the other endpoints until a
+// probe succeeds. Set Client.Breakers to turn them on.
+type Breakers struct {
+	options BreakerOptions
+
+	// OnStateChange is called when an endpoint's circuit changes state, if set
+	OnStateChange func(endpoint string, from, to BreakerState)
+
+	mu        sync.Mutex
+	endpoints map[string]*breaker
+	// changes are the state changes to pass to OnStateChange once mu is released
+	changes []stateChange
+	// probes counts the probes let through, to give each one its own probeID
+	probes probeID
+	now    func() time.Time
+}
+
+type stateChange struct {
+	endpoint string
+	from, to BreakerState
+}
+
+// breaker is the state of one endpoint
+type breaker struct {
+	status    BreakerStatus
+	successes int
+	// probe is the request holding the half-open probe slot, or zero if it is free
+	probe probeID
+}
+
+// probeID identifies the request that holds a half-open circuit's probe slot. Requests that
+// are not probes get zero.
+type probeID uint64
+
+// NewBreakers returns circuit breakers with the options, using the defaults for anything not set
+func NewBreakers(options BreakerOptions) *Breakers {
+	if options.Failures < 1 {
+		options.Failures = DefaultBreakerFailures
+	}
+	if options.ProbeInterval <= 0 {
+		options.ProbeInterval = DefaultBreakerProbeInterval
+	}
+	if options.Successes < 1 {
+		options.Successes = 1
+	}
+	if options.IsFailure == nil {
+		options.IsFailure = isAllocatorFailure
+	}
+	return &Breakers{
+		options:   options,
+		endpoints: make(map[string]*breaker),
+		now:       time.Now,
+	}
+}
+
+// isAllocatorFailure returns true for the errors that mean the allocator is in trouble,
+// rather than the request or the fleet
+func isAllocatorFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// State returns the state of an endpoint's circuit. Endpoints that have not been used yet are closed.
+func (b *Breakers) State(endpoint string) BreakerState {
+	return b.Status(endpoint).State
+}
+
+// Status returns the state and counters of an endpoint's circuit
+func (b *Breakers) Status(endpoint string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.get(endpoint).status
+}
+
+// Statuses returns the state and counters of every endpoint that has been used, by endpoint
+func (b *Breakers) Statuses() map[string]BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	statuses := make(map[string]BreakerStatus, len(b.endpoints))
+	for endpoint, e := range b.endpoints {
+		statuses[endpoint] = e.status
+	}
+	return statuses
+}
+
+// get returns the breaker for an endpoint, making a closed one if there is none. b.mu must be held.
+func (b *Breakers) get(endpoint string) *breaker {
+	endpoint = withDefaultPort(endpoint)
+	e, ok := b.endpoints[endpoint]
+	if !ok {
+		e = &breaker{status: BreakerStatus{State: BreakerClosed, Since: b.now()}}
+		b.endpoints[endpoint] = e
+	}
+	return e
+}
+
+// unlock releases b.mu and then calls OnStateChange for any state changes made while it was held
+func (b *Breakers) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if b.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.OnStateChange(change.endpoint, change.from, change.to)
+	}
+}
+
+// setState moves an endpoint's circuit to a new state. b.mu must be held.
+func (b *Breakers) setState(endpoint string, e *breaker, state BreakerState) {
+	from := e.status.State
+	if from == state {
+		return
+	}
+	e.status.State = state
+	e.status.Since = b.now()
+	e.successes = 0
+	e.probe = 0
+	if state == BreakerOpen {
+		e.status.Opened++
+	}
+	klog.V(2).Infof("circuit for %s is now %s", endpoint, state)
+	b.changes = append(b.changes, stateChange{endpoint: endpoint, from: from, to: state})
+}
+
+// allow returns true if a request can be sent to the endpoint. In the half-open state only
+// one probe is let through at a time. The caller must report the result with record, or give
+// the request up with release, passing the probeID so that only the probe can close the circuit.
+func (b *Breakers) allow(endpoint string) (probeID, bool) {
+	endpoint = withDefaultPort(endpoint)
+	b.mu.Lock()
+	defer b.unlock()
+	e := b.get(endpoint)
+	if e.status.State == BreakerOpen && b.now().Sub(e.status.Since) >= b.options.ProbeInterval {
+		b.setState(endpoint, e, BreakerHalfOpen)
+	}
+	switch e.status.State {
+	case BreakerClosed:
+		return 0, true
+	case BreakerHalfOpen:
+		if e.probe == 0 {
+			b.probes++
+			e.probe = b.probes
+			return e.probe, true
+		}
+	}
+	e.status.Rejected++
+	return 0, false
+}
+
+// record counts the result of a request to the endpoint. Only the probe moves a half-open
+// circuit, since other requests still finishing were sent before the circuit opened.
+func (b *Breakers) record(endpoint string, probe probeID, err error) {
+	endpoint = withDefaultPort(endpoint)
+	failed := err != nil && b.options.IsFailure(err)
+	b.mu.Lock()
+	defer b.unlock()
+	e := b.get(endpoint)
+	isProbe := probe != 0 && probe == e.probe
+	if isProbe {
+		e.probe = 0
+	}
+	if failed {
+		e.status.Failures++
+		switch {
+		case e.status.State == BreakerHalfOpen && isProbe:
+			b.setState(endpoint, e, BreakerOpen)
+		case e.status.State == BreakerClosed && e.status.Failures >= b.options.Failures:
+			b.setState(endpoint, e, BreakerOpen)
+		}
+		return
+	}
+	e.status.Failures = 0
+	if e.status.State == BreakerHalfOpen && isProbe {
+		e.successes++
+		if e.successes >= b.options.Successes {
+			b.setState(endpoint, e, BreakerClosed)
+		}
+	}
+}
+
+// release gives up a request that was allowed but never sent, e.g. because the context was
+// cancelled, so that a half-open circuit can probe again
+func (b *Breakers) release(endpoint string, probe probeID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.get(endpoint)
+	if probe != 0 && probe == e.probe {
+		e.probe = 0
+	}
+}
+
+// pickEndpoint returns the endpoint for the next attempt. Without breakers it is always the
+// current endpoint. With them it is the first endpoint in rank order whose circuit lets the
+// request through, passing over the one that just failed if there is another choice. If the
+// request is a half-open probe, its probeID is returned too.
+func (c *Client) pickEndpoint(failed string) (string, probeID, error) {
+	if c.Breakers == nil {
+		return c.CurrentEndpoint(), 0, nil
+	}
+	ranked := c.rankedEndpoints()
+	if failed != "" && len(ranked) > 1 {
+		for i, endpoint := range ranked {
+			if endpoint == failed {
+				ranked = append(append(ranked[:i:i], ranked[i+1:]...), failed)
+				break
+			}
+		}
+	}
+	for _, endpoint := range ranked {
+		if probe, ok := c.Breakers.allow(endpoint); ok {
+			return endpoint, probe, nil
+		}
+	}
+	return "", 0, status.Errorf(codes.Unavailable, "the circuit is open for every endpoint: %s", strings.Join(ranked, ", "))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestBreakers(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBreakers(BreakerOptions{Failures: 2, ProbeInterval: 10 * time.Second})
+	b.now = func() time.Time { return now }
+	changes := []string{}
+	b.OnStateChange = func(endpoint string, from, to BreakerState) {
+		changes = append(changes, fmt.Sprintf("%s %s->%s", endpoint, from, to))
+	}
+	down := status.Error(codes.Unavailable, "down")
+
+	assert.Equal(t, BreakerClosed, b.State("east"))
+	probe, ok := b.allow("east")
+	assert.True(t, ok)
+	assert.Zero(t, probe, "requests to a closed circuit are not probes")
+	b.record("east", 0, status.Error(codes.ResourceExhausted, "no gameservers"))
+	b.record("east", 0, down)
+	assert.Equal(t, BreakerClosed, b.State("east"), "only allocator failures count")
+	b.record("east", 0, down)
+	assert.Equal(t, BreakerOpen, b.State("east:443"))
+	_, ok = b.allow("east")
+	assert.False(t, ok)
+
+	now = now.Add(10 * time.Second)
+	probe, ok = b.allow("east")
+	assert.True(t, ok, "the first request after the probe interval is a probe")
+	assert.NotZero(t, probe)
+	assert.Equal(t, BreakerHalfOpen, b.State("east"))
+	_, ok = b.allow("east")
+	assert.False(t, ok, "only one probe at a time")
+	b.record("east", probe, down)
+	assert.Equal(t, BreakerOpen, b.State("east"))
+
+	now = now.Add(10 * time.Second)
+	probe, ok = b.allow("east")
+	assert.True(t, ok)
+	b.record("east", probe, nil)
+	assert.Equal(t, BreakerClosed, b.State("east"))
+
+	assert.Equal(t, []string{
+		"east:443 closed->open",
+		"east:443 open->half-open",
+		"east:443 half-open->open",
+		"east:443 open->half-open",
+		"east:443 half-open->closed",
+	}, changes)
+	status := b.Statuses()["east:443"]
+	assert.Equal(t, BreakerStatus{State: BreakerClosed, Since: now, Opened: 2, Rejected: 2}, status)
+}
+
+func TestBreakers_probe(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBreakers(BreakerOptions{Failures: 1, ProbeInterval: 10 * time.Second})
+	b.now = func() time.Time { return now }
+	down := status.Error(codes.Unavailable, "down")
+
+	// A request sent while the circuit was closed is still in flight when it opens
+	stale, ok := b.allow("east")
+	require.True(t, ok)
+	b.record("east", 0, down)
+	now = now.Add(10 * time.Second)
+	probe, ok := b.allow("east")
+	require.True(t, ok)
+
+	b.record("east", stale, nil)
+	assert.Equal(t, BreakerHalfOpen, b.State("east"), "only the probe can close the circuit")
+	_, ok = b.allow("east")
+	assert.False(t, ok, "the probe still holds the slot")
+	b.record("east", stale, down)
+	assert.Equal(t, BreakerHalfOpen, b.State("east"), "only the probe can open the circuit again")
+	b.release("east", stale)
+	_, ok = b.allow("east")
+	assert.False(t, ok, "releasing another request does not free the slot")
+
+	b.release("east", probe)
+	next, ok := b.allow("east")
+	assert.True(t, ok, "a released probe frees the slot")
+	assert.NotEqual(t, probe, next)
+	b.record("east", probe, nil)
+	assert.Equal(t, BreakerHalfOpen, b.State("east"), "the released probe no longer counts")
+	b.record("east", next, nil)
+	assert.Equal(t, BreakerClosed, b.State("east"))
+}
+
+func TestClient_pickEndpoint(t *testing.T) {
+	c := &Client{
+		Endpoint:  "east",
+		Endpoints: map[string]string{"east": "", "west:443": "", "central:443": ""},
+	}
+	endpoint, _, err := c.pickEndpoint("")
+	require.NoError(t, err)
+	assert.Equal(t, "east", endpoint, "without breakers the current endpoint is always used")
+
+	c.Breakers = NewBreakers(BreakerOptions{Failures: 1})
+	endpoint, _, err = c.pickEndpoint("")
+	require.NoError(t, err)
+	assert.Equal(t, "east:443", endpoint)
+	endpoint, _, err = c.pickEndpoint("east:443")
+	require.NoError(t, err)
+	assert.Equal(t, "central:443", endpoint, "the endpoint that just failed goes last")
+
+	for _, e := range []string{"east", "west", "central"} {
+		c.Breakers.record(e, 0, status.Error(codes.Unavailable, "down"))
+	}
+	_, _, err = c.pickEndpoint("")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestClient_Breakers(t *testing.T) {
+	primary, secondary, c := newFailoverPair(t, readyGameServers("gs-0", "gs-1", "gs-2", "gs-3")...)
+	primary.Script(allocatortest.Fail(codes.Unavailable, "down"), allocatortest.Fail(codes.Unavailable, "down"))
+	c.MaxRetries = 1
+	c.Breakers = NewBreakers(BreakerOptions{Failures: 2, ProbeInterval: time.Minute})
+	now := time.Now()
+	c.Breakers.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		allocation, err := c.AllocateGameserverWithRetryContext(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, secondary.Address, allocation.Endpoint)
+	}
+	assert.Equal(t, BreakerOpen, c.Breakers.State(primary.Address))
+	assert.Equal(t, primary.Address, c.Endpoint, "the preferred endpoint does not change")
+
+	// The open circuit sends traffic straight to the secondary
+	allocation, err := c.AllocateGameserverWithRetryContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, secondary.Address, allocation.Endpoint)
+	assert.Equal(t, 1, allocation.Attempts)
+	assert.Len(t, primary.Requests(), 2)
+
+	// Once the primary answers a probe, traffic returns to it
+	now = now.Add(time.Minute)
+	allocation, err = c.AllocateGameserverWithRetryContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, primary.Address, allocation.Endpoint)
+	assert.Equal(t, BreakerClosed, c.Breakers.State(primary.Address))
+}
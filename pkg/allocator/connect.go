@@ -18,60 +18,158 @@ package allocator
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/klog"
 )
 
+// responseTimeout is how long to wait for the gameserver to answer a hello
+const responseTimeout = 10 * time.Second
+
+// LoadOptions configures a load test run
+type LoadOptions struct {
+	// Count is the number of sessions to start
+	Count int `json:"count"`
+	// Delay is the time to wait between starting sessions
+	Delay time.Duration `json:"delay"`
+	// Duration is how long each session holds its connection open
+	Duration time.Duration `json:"duration"`
+	// Protocol is the gameserver protocol, either udp or tcp
+	Protocol string `json:"protocol"`
+	// GracePeriod is how long in-flight sessions are given to say goodbye once
+	// the context is cancelled
+	GracePeriod time.Duration `json:"gracePeriod"`
+}
+
 // RunLoad runs many concurrent game connections on a simple UDP or TCP server
 // This is designed to test the allocator service and autoscaling of the game servers.
-func (c *Client) RunLoad(count int, delay int, duration int, proto string) error {
+// Cancelling the context stops new allocations and ends in-flight sessions early. Sessions
+// that have not finished within the grace period are aborted, which closes their connections,
+// and counted as abandoned in the report. RunLoad returns once every session has stopped.
+func (c *Client) RunLoad(ctx context.Context, opts LoadOptions, report *LoadReport) error {
 	var wg sync.WaitGroup
-	for i := 0; i < count; i++ {
+	var active int32
+	abort, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	if c.Breakers != nil {
+		report.watchBreakers(c.Breakers)
+	}
+
+	for i := 0; i < opts.Count; i++ {
+		if ctx.Err() != nil {
+			klog.V(2).Infof("shutting down - not starting the remaining %d sessions", opts.Count-i)
+			break
+		}
 		wg.Add(1)
-		go c.testConnection(i, &wg, duration, proto)
-		time.Sleep(time.Duration(delay) * time.Second)
+		atomic.AddInt32(&active, 1)
+		report.sessionStarted()
+		go func(id int) {
+			defer wg.Done()
+			defer atomic.AddInt32(&active, -1)
+			result := c.testConnection(ctx, abort, id, opts.Duration, opts.Protocol, report)
+			if abort.Err() != nil {
+				result = SessionAbandoned
+			}
+			report.recordSession(result, 1)
+		}(i)
+		if i == opts.Count-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(opts.Delay):
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		report.finish(ctx.Err() != nil)
+		return nil
+	case <-ctx.Done():
 	}
-	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(opts.GracePeriod):
+		klog.Warningf("grace period of %s expired with %d sessions still running - abandoning them", opts.GracePeriod, atomic.LoadInt32(&active))
+		abandon()
+		<-done
+	}
+	report.finish(true)
 	return nil
 }
 
-func (c *Client) testConnection(id int, wg *sync.WaitGroup, duration int, proto string) {
-	defer wg.Done()
-
-	a, err := c.AllocateGameserverWithRetry()
+// testConnection runs one session. Cancelling ctx ends it cleanly, and cancelling abort cuts it off.
+func (c *Client) testConnection(ctx, abort context.Context, id int, duration time.Duration, proto string, report *LoadReport) SessionResult {
+	// Only this session's attempts go into the report, not those of other callers of the client
+	a, err := c.allocateWithRetry(ctx, report.recordAttempt)
+	report.recordAllocation(err)
 	if err != nil {
+		if ctx.Err() != nil {
+			klog.V(2).Infof("%d - allocation cancelled by shutdown", id)
+			return SessionInterrupted
+		}
 		klog.Error(err.Error())
-		return
+		return SessionFailed
 	}
 
 	klog.V(3).Infof("%d - got allocation %s %d. Proceeding to connection...\n", id, a.Address, a.Port)
-	err = a.testConnection(id, duration, proto)
+	err = a.testConnection(ctx, abort, id, duration, proto)
 	if err != nil {
 		klog.Error(err)
+		return SessionFailed
+	}
+	if ctx.Err() != nil {
+		return SessionInterrupted
+	}
+	return SessionCompleted
+}
+
+// wait sleeps for the duration of a session, or until the context is cancelled
+func wait(ctx context.Context, id int, duration time.Duration) {
+	klog.V(3).Infof("%d - sleeping %s to view logs", id, duration)
+	select {
+	case <-ctx.Done():
+		klog.V(2).Infof("%d - shutting down, ending session early", id)
+	case <-time.After(duration):
 	}
 }
 
 // testConnection tests a series of connections to the simple-udp server gameserver example
-func (a *Allocation) testConnection(id int, duration int, proto string) error {
-	endpoint := fmt.Sprintf("%s:%d", a.Address, a.Port)
+// The session is cut short, but still closed cleanly, if ctx is cancelled. Cancelling abort
+// closes the connection straight away, which also interrupts a dial or a read.
+func (a *Allocation) testConnection(ctx, abort context.Context, id int, duration time.Duration, proto string) error {
+	endpoint := net.JoinHostPort(a.Address, strconv.Itoa(int(a.Port)))
 
 	switch proto {
 	case "tcp":
-		conn, err := net.Dial("tcp", endpoint)
+		conn, err := (&net.Dialer{}).DialContext(abort, "tcp", endpoint)
 		if err != nil {
 			return err
 		}
+		defer conn.Close()
+		defer closeOnAbort(abort, conn)()
 		klog.V(2).Infof("%d - connected to gameserver and sending hello", id)
 		fmt.Fprintf(conn, "HELLO\n")
+		_ = conn.SetReadDeadline(time.Now().Add(responseTimeout))
 		status, _ := bufio.NewReader(conn).ReadString('\n')
 		klog.V(3).Infof("%d - response: %s", id, status)
 
-		klog.V(3).Infof("%d - sleeping %d seconds to view logs", id, duration)
-		time.Sleep(time.Duration(duration) * time.Second)
+		wait(ctx, id, duration)
 
 		klog.V(3).Infof("%d - closing connection", id)
 		fmt.Fprintf(conn, "EXIT\n")
@@ -83,6 +181,7 @@ func (a *Allocation) testConnection(id int, duration int, proto string) error {
 			return err
 		}
 		defer conn.Close()
+		defer closeOnAbort(abort, conn)()
 
 		dst, err := net.ResolveUDPAddr(proto, endpoint)
 		if err != nil {
@@ -99,8 +198,7 @@ func (a *Allocation) testConnection(id int, duration int, proto string) error {
 		}
 
 		// Wait
-		klog.V(3).Infof("%d - sleeping %d seconds to view logs", id, duration)
-		time.Sleep(time.Duration(duration) * time.Second)
+		wait(ctx, id, duration)
 
 		// Goodbye
 		msg = fmt.Sprintf("Goodbye from process %d.", id)
@@ -119,3 +217,16 @@ func (a *Allocation) testConnection(id int, duration int, proto string) error {
 		return fmt.Errorf("proto must be one of (udp|tcp)")
 	}
 }
+
+// closeOnAbort closes the connection as soon as abort is cancelled, until the returned func is called
+func closeOnAbort(abort context.Context, conn io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-abort.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
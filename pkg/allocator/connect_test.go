@@ -0,0 +1,171 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestClient_RunLoad(t *testing.T) {
+	tests := []struct {
+		name          string
+		protocol      string
+		count         int
+		fleetSize     int
+		crash         bool
+		wantCompleted int
+		wantFailed    int
+		wantMessages  []string
+	}{
+		{
+			name:          "udp",
+			protocol:      "udp",
+			count:         3,
+			fleetSize:     3,
+			wantCompleted: 3,
+			wantMessages:  []string{"Hello from process", "Goodbye from process", "EXIT"},
+		},
+		{
+			name:          "tcp",
+			protocol:      "tcp",
+			count:         3,
+			fleetSize:     3,
+			wantCompleted: 3,
+			wantMessages:  []string{"HELLO", "EXIT"},
+		},
+		{
+			name:          "fleet exhausted",
+			protocol:      "tcp",
+			count:         3,
+			fleetSize:     2,
+			wantCompleted: 2,
+			wantFailed:    1,
+			wantMessages:  []string{"HELLO", "EXIT"},
+		},
+		{
+			name:       "gameserver crashed",
+			protocol:   "tcp",
+			count:      2,
+			fleetSize:  2,
+			crash:      true,
+			wantFailed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gameServer, err := allocatortest.NewSimpleGameServer(tt.protocol)
+			require.NoError(t, err)
+			defer gameServer.Close()
+			if tt.crash {
+				gameServer.Crash()
+			}
+
+			allocatorServer := newFakeAllocator(t, nil)
+			for i := 0; i < tt.fleetSize; i++ {
+				allocatorServer.AddGameServers(gameServer.GameServer(fmt.Sprintf("gs-%d", i)))
+			}
+			c := newFakeClient(t, allocatorServer)
+
+			report := NewLoadReport()
+			opts := LoadOptions{Count: tt.count, Duration: 10 * time.Millisecond, Protocol: tt.protocol, GracePeriod: time.Second}
+			require.NoError(t, c.RunLoad(context.Background(), opts, report))
+
+			summary := report.Summary()
+			assert.Equal(t, tt.count, summary.SessionsStarted)
+			assert.Equal(t, tt.fleetSize, summary.Allocations)
+			assert.Equal(t, tt.wantCompleted, summary.SessionsCompleted)
+			assert.Equal(t, tt.wantFailed, summary.SessionsFailed)
+
+			// The last message may still be in flight when the session ends
+			require.Eventually(t, func() bool {
+				sessions := gameServer.Sessions()
+				return len(sessions) == tt.wantCompleted && (len(sessions) == 0 || sessions[len(sessions)-1].Exited)
+			}, time.Second, 10*time.Millisecond)
+			for _, session := range gameServer.Sessions() {
+				require.Len(t, session.Messages, len(tt.wantMessages))
+				for i, want := range tt.wantMessages {
+					assert.Contains(t, session.Messages[i], want)
+				}
+				assert.True(t, session.Exited)
+			}
+		})
+	}
+}
+
+func TestClient_RunLoad_attempts(t *testing.T) {
+	gameServer, err := allocatortest.NewSimpleGameServer("tcp")
+	require.NoError(t, err)
+	defer gameServer.Close()
+	allocatorServer := newFakeAllocator(t, nil)
+	for i := 0; i < 3; i++ {
+		allocatorServer.AddGameServers(gameServer.GameServer(fmt.Sprintf("gs-%d", i)))
+	}
+	c := newFakeClient(t, allocatorServer)
+	var attempts int32
+	c.OnAttempt = func(Attempt) { atomic.AddInt32(&attempts, 1) }
+
+	// An allocation outside the load test, made while it runs, is not part of its report
+	done := make(chan error)
+	go func() {
+		_, err := c.AllocateGameserverWithRetryContext(context.Background())
+		done <- err
+	}()
+	report := NewLoadReport()
+	opts := LoadOptions{Count: 2, Duration: 10 * time.Millisecond, Protocol: "tcp", GracePeriod: time.Second}
+	require.NoError(t, c.RunLoad(context.Background(), opts, report))
+	require.NoError(t, <-done)
+
+	assert.Equal(t, 2, report.Summary().Endpoints[allocatorServer.Address].Attempts)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts), "the client's own hook still sees every attempt")
+}
+
+func TestClient_RunLoad_abandoned(t *testing.T) {
+	// The gameserver never answers the hello, so the session is stuck reading
+	gameServer, err := allocatortest.NewSimpleGameServer("tcp")
+	require.NoError(t, err)
+	defer gameServer.Close()
+	gameServer.SetFaults(allocatortest.Faults{DropRate: 1})
+	allocatorServer := newFakeAllocator(t, nil)
+	allocatorServer.AddGameServers(gameServer.GameServer("gs-0"))
+	c := newFakeClient(t, allocatorServer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.Eventually(t, func() bool { return len(gameServer.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+		cancel()
+	}()
+	report := NewLoadReport()
+	opts := LoadOptions{Count: 1, Duration: time.Minute, Protocol: "tcp", GracePeriod: 100 * time.Millisecond}
+	start := time.Now()
+	require.NoError(t, c.RunLoad(ctx, opts, report))
+	assert.Less(t, int64(time.Since(start)), int64(responseTimeout))
+
+	summary := report.Summary()
+	assert.Equal(t, 1, summary.SessionsAbandoned)
+	assert.Equal(t, 0, summary.SessionsFailed)
+	assert.True(t, summary.Interrupted)
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultBlackholeTimeout is how long a request to a blackholed endpoint hangs before it fails,
+// the same as gRPC's default connection timeout
+const DefaultBlackholeTimeout = 20 * time.Second
+
+// FaultError fails requests with a gRPC code
+type FaultError struct {
+	// Code is the gRPC code, either a number or a name like UNAVAILABLE
+	Code codes.Code `json:"code"`
+	// Probability is the chance, from 0 to 1, that a request fails with the code
+	Probability float64 `json:"probability"`
+}
+
+// Faults inject trouble into allocation requests, to exercise retries and failover on purpose.
+// They are applied in order: blackholed endpoints, latency, errors, then dropped responses.
+type Faults struct {
+	// Latency is added before every request is sent
+	Latency time.Duration
+	// Errors fail requests before they are sent. Each one is rolled for separately.
+	Errors []FaultError
+	// DropRate is the chance, from 0 to 1, that a response is lost on the way back. The
+	// allocator has still allocated the gameserver, as with a real lost response, and the
+	// request fails with Unavailable.
+	DropRate float64
+	// Blackhole lists endpoints that never answer, either host:port or just the host.
+	// Requests to them hang for BlackholeTimeout, or until the context is done, then fail
+	// with Unavailable.
+	Blackhole []string
+	// BlackholeTimeout defaults to DefaultBlackholeTimeout
+	BlackholeTimeout time.Duration
+	// Seed makes the random choices repeatable. Zero seeds from the clock.
+	Seed int64
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// faultsFile is the file format of Faults, with durations written like 250ms
+type faultsFile struct {
+	Latency          string       `json:"latency"`
+	Errors           []FaultError `json:"errors"`
+	DropRate         float64      `json:"dropRate"`
+	Blackhole        []string     `json:"blackhole"`
+	BlackholeTimeout string       `json:"blackholeTimeout"`
+	Seed             int64        `json:"seed"`
+}
+
+// LoadFaults reads faults from a YAML or JSON file
+func LoadFaults(path string) (*Faults, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	faults, err := ParseFaults(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read faults file %s - %s", path, err.Error())
+	}
+	return faults, nil
+}
+
+// ParseFaults reads faults from YAML or JSON, e.g.
+//
+//	latency: 250ms
+//	errors:
+//	- code: UNAVAILABLE
+//	  probability: 0.2
+//	dropRate: 0.05
+//	blackhole: [allocator.eu.example.com:443]
+//	blackholeTimeout: 5s
+func ParseFaults(data []byte) (*Faults, error) {
+	file := &faultsFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, err
+	}
+	faults := &Faults{
+		Errors:    file.Errors,
+		DropRate:  file.DropRate,
+		Blackhole: file.Blackhole,
+		Seed:      file.Seed,
+	}
+	var err error
+	if file.Latency != "" {
+		if faults.Latency, err = time.ParseDuration(file.Latency); err != nil {
+			return nil, err
+		}
+	}
+	if file.BlackholeTimeout != "" {
+		if faults.BlackholeTimeout, err = time.ParseDuration(file.BlackholeTimeout); err != nil {
+			return nil, err
+		}
+	}
+	return faults, faults.Validate()
+}
+
+// Validate checks that the probabilities are between 0 and 1 and the durations are not negative
+func (f *Faults) Validate() error {
+	if f.DropRate < 0 || f.DropRate > 1 {
+		return fmt.Errorf("dropRate must be between 0 and 1")
+	}
+	for _, e := range f.Errors {
+		if e.Probability < 0 || e.Probability > 1 {
+			return fmt.Errorf("the probability of %s errors must be between 0 and 1", e.Code)
+		}
+		if e.Code == codes.OK {
+			return fmt.Errorf("errors need a code other than OK")
+		}
+	}
+	if f.Latency < 0 || f.BlackholeTimeout < 0 {
+		return fmt.Errorf("latency and blackholeTimeout cannot be negative")
+	}
+	return nil
+}
+
+// roll returns true with the given probability
+func (f *Faults) roll(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.random == nil {
+		seed := f.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		f.random = rand.New(rand.NewSource(seed))
+	}
+	return f.random.Float64() < probability
+}
+
+func (f *Faults) blackholed(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	for _, b := range f.Blackhole {
+		if b == endpoint || b == host {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs the request through the faults
+func (f *Faults) apply(ctx context.Context, endpoint string, send func() (*pb.AllocationResponse, error)) (*pb.AllocationResponse, error) {
+	if f.blackholed(endpoint) {
+		timeout := f.BlackholeTimeout
+		if timeout == 0 {
+			timeout = DefaultBlackholeTimeout
+		}
+		klog.V(2).Infof("fault injection: %s is blackholed", endpoint)
+		if err := sleepContext(ctx, timeout); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unavailable, "fault injection: %s is blackholed", endpoint)
+	}
+	if err := sleepContext(ctx, f.Latency); err != nil {
+		return nil, err
+	}
+	for _, e := range f.Errors {
+		if f.roll(e.Probability) {
+			klog.V(2).Infof("fault injection: failing request to %s with %s", endpoint, e.Code)
+			return nil, status.Errorf(e.Code, "fault injection: %s", e.Code)
+		}
+	}
+	response, err := send()
+	if err != nil {
+		return nil, err
+	}
+	if f.roll(f.DropRate) {
+		klog.V(2).Infof("fault injection: dropping the response from %s for %s", endpoint, response.GetGameServerName())
+		return nil, status.Error(codes.Unavailable, "fault injection: response dropped")
+	}
+	return response, nil
+}
+
+// sleepContext waits for the duration, or fails with the context's error as a gRPC status
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *Faults
+		wantErr bool
+	}{
+		{
+			name: "everything",
+			data: `
+latency: 250ms
+errors:
+- code: UNAVAILABLE
+  probability: 0.2
+- code: 8
+  probability: 0.1
+dropRate: 0.05
+blackhole: [allocator.eu.example.com]
+blackholeTimeout: 5s
+seed: 42
+`,
+			want: &Faults{
+				Latency:          250 * time.Millisecond,
+				Errors:           []FaultError{{Code: codes.Unavailable, Probability: 0.2}, {Code: codes.ResourceExhausted, Probability: 0.1}},
+				DropRate:         0.05,
+				Blackhole:        []string{"allocator.eu.example.com"},
+				BlackholeTimeout: 5 * time.Second,
+				Seed:             42,
+			},
+		},
+		{name: "bad duration", data: "latency: soon", wantErr: true},
+		{name: "bad probability", data: "dropRate: 2", wantErr: true},
+		{name: "OK is not an error", data: "errors: [{code: OK, probability: 1}]", wantErr: true},
+		{name: "unknown field", data: "jitter: 1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFaults([]byte(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClient_Faults(t *testing.T) {
+	tests := []struct {
+		name         string
+		faults       *Faults
+		maxRetries   int
+		wantCode     codes.Code
+		wantServed   int
+		wantFailover bool
+		minLatency   time.Duration
+	}{
+		{
+			name:       "latency",
+			faults:     &Faults{Latency: 50 * time.Millisecond},
+			wantServed: 1,
+			minLatency: 50 * time.Millisecond,
+		},
+		{
+			name:     "injected error",
+			faults:   &Faults{Errors: []FaultError{{Code: codes.PermissionDenied, Probability: 1}}},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:       "dropped response leaks the gameserver",
+			faults:     &Faults{DropRate: 1},
+			wantCode:   codes.Unavailable,
+			wantServed: 1,
+		},
+		{
+			name:         "blackholed endpoint fails over",
+			faults:       &Faults{BlackholeTimeout: 10 * time.Millisecond},
+			maxRetries:   1,
+			wantServed:   1,
+			wantFailover: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary, c := newFailoverPair(t, readyGameServers("gs-1")...)
+			c.MaxRetries = tt.maxRetries
+			if tt.wantFailover {
+				tt.faults.Blackhole = []string{primary.Address}
+			}
+			c.Faults = tt.faults
+
+			allocation, err := c.AllocateGameserverWithRetryContext(context.Background())
+			served := primary
+			if tt.wantFailover {
+				served = secondary
+			}
+			assert.Len(t, served.Requests(), tt.wantServed)
+			if tt.wantCode != codes.OK {
+				// The retry loop wraps the error, so the status code is only in the message
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "code = "+tt.wantCode.String())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, served.Address, allocation.Endpoint)
+			assert.GreaterOrEqual(t, int64(allocation.Latency), int64(tt.minLatency))
+		})
+	}
+
+	t.Run("blackhole gives up with the context", func(t *testing.T) {
+		c := &Client{Endpoint: "allocator.example.com:443", Faults: &Faults{Blackhole: []string{"allocator.example.com"}}}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := c.sendRequest(ctx, c.Endpoint, c.newAllocationRequest())
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// DefaultHedgeTimeout is how long a hedged request that lost the race may keep running by default
+const DefaultHedgeTimeout = 30 * time.Second
+
+// Hedge races two endpoints for latency-critical allocations. If the first endpoint has not
+// answered after Delay, the same request is sent to the next-best endpoint, and the first
+// success wins. The slower request is not cancelled, because the allocator may already have
+// allocated a gameserver for it. If it succeeds too, that gameserver is passed to Extra.
+type Hedge struct {
+	// Delay is how long to wait for the first endpoint before hedging. Zero sends both requests at once.
+	Delay time.Duration
+	// Timeout bounds the slower request once the winner has been returned. From then on it no
+	// longer follows the caller's context, which is often cancelled right away. Defaults to
+	// DefaultHedgeTimeout.
+	Timeout time.Duration
+	// Extra is called with every gameserver that was allocated by the slower request. It
+	// should release it, e.g. by deleting the gameserver, or at least record it. If nil, a
+	// warning is logged, and the gameserver stays allocated.
+	Extra func(*Allocation)
+}
+
+type hedgeResult struct {
+	allocation *Allocation
+	err        error
+}
+
+// allocateHedged makes one allocation attempt, hedged to a second endpoint after the delay
+func (c *Client) allocateHedged(ctx context.Context, endpoint string, probe probeID, number int, onAttempt func(Attempt)) (*Allocation, error) {
+	options := *c.Hedge
+	if options.Timeout == 0 {
+		options.Timeout = DefaultHedgeTimeout
+	}
+	// The requests run on a context of their own, so that the caller returning does not cancel
+	// the slower one. Until there is a winner, the caller can still give up on both.
+	requestCtx, cancel := context.WithCancel(detachedContext{parent: ctx})
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// A caller that cancels once it has the winner must not cancel the slower request
+			select {
+			case <-returned:
+			default:
+				cancel()
+			}
+		case <-returned:
+		}
+	}()
+
+	results := make(chan hedgeResult, 2)
+	send := func(endpoint string, probe probeID) {
+		go func() {
+			allocation, err := c.allocateGameserver(requestCtx, endpoint, probe, number, onAttempt)
+			results <- hedgeResult{allocation: allocation, err: err}
+		}()
+	}
+	send(endpoint, probe)
+	pending := 1
+
+	timer := time.NewTimer(options.Delay)
+	defer timer.Stop()
+	hedge := timer.C
+	hedged := false
+	var err error
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			if ctx.Err() != nil {
+				continue
+			}
+			next, nextProbe, ok := c.hedgeEndpoint(endpoint)
+			if !ok {
+				continue
+			}
+			klog.V(2).Infof("%s has not answered after %s - sending the same request to %s", endpoint, options.Delay, next)
+			send(next, nextProbe)
+			pending++
+			hedged = true
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				result.allocation.Hedged = hedged
+				if pending == 0 {
+					cancel()
+					return result.allocation, nil
+				}
+				c.hedges.Add(1)
+				go func(pending int) {
+					defer c.hedges.Done()
+					defer cancel()
+					// The slower request gets Timeout from now, not from when it was sent
+					timeout := time.AfterFunc(options.Timeout, cancel)
+					defer timeout.Stop()
+					options.collectExtra(results, pending)
+				}(pending)
+				return result.allocation, nil
+			}
+			err = result.err
+			if pending == 0 {
+				// Either the hedge failed too, or the first request failed before the delay and
+				// the usual retries take over
+				cancel()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, err
+			}
+		}
+	}
+}
+
+// hedgeEndpoint returns the next-best endpoint after the one already tried, and its probeID
+// if the hedge is a half-open probe
+func (c *Client) hedgeEndpoint(tried string) (string, probeID, bool) {
+	tried = withDefaultPort(tried)
+	for _, endpoint := range c.rankedEndpoints() {
+		if endpoint == tried {
+			continue
+		}
+		if c.Breakers == nil {
+			return endpoint, 0, true
+		}
+		if probe, ok := c.Breakers.allow(endpoint); ok {
+			return endpoint, probe, true
+		}
+	}
+	return "", 0, false
+}
+
+// WaitForHedges waits until the hedged requests that lost a race have finished, and anything
+// they allocated has been passed to Extra. Call it before exiting so that no extra gameserver
+// goes unnoticed. It gives up when the context is done.
+func (c *Client) WaitForHedges(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.hedges.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// collectExtra waits for the requests that lost the race, and hands over anything they allocated
+func (h Hedge) collectExtra(results <-chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.err != nil {
+			continue
+		}
+		if h.Extra == nil {
+			klog.Warningf("the hedged request to %s also allocated %s, which is not released", result.allocation.Endpoint, result.allocation.GameServerName)
+			continue
+		}
+		h.Extra(result.allocation)
+	}
+}
+
+// detachedContext keeps the values of its parent, but not its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (d detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (d detachedContext) Done() <-chan struct{} { return nil }
+
+func (d detachedContext) Err() error { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
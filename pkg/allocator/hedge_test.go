@@ -0,0 +1,153 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestClient_Hedge(t *testing.T) {
+	tests := []struct {
+		name         string
+		primarySlow  bool
+		cancel       bool
+		timeout      time.Duration
+		primary      []allocatortest.Behavior
+		secondary    []allocatortest.Behavior
+		wantErr      bool
+		wantFrom     string
+		wantHedged   bool
+		wantExtra    bool
+		wantHedgeReq int
+	}{
+		{
+			name:     "fast primary is not hedged",
+			wantFrom: "primary",
+		},
+		{
+			name:         "slow primary loses the race and its gameserver is extra",
+			primarySlow:  true,
+			wantFrom:     "secondary",
+			wantHedged:   true,
+			wantExtra:    true,
+			wantHedgeReq: 1,
+		},
+		{
+			name:         "cancelling the context after the winner returns does not cancel the slower request",
+			primarySlow:  true,
+			cancel:       true,
+			wantFrom:     "secondary",
+			wantHedged:   true,
+			wantExtra:    true,
+			wantHedgeReq: 1,
+		},
+		{
+			name:         "the timeout starts when the winner returns",
+			primarySlow:  true,
+			timeout:      180 * time.Millisecond,
+			wantFrom:     "secondary",
+			wantHedged:   true,
+			wantExtra:    true,
+			wantHedgeReq: 1,
+		},
+		{
+			name:         "the slower request is cancelled after the timeout",
+			primarySlow:  true,
+			timeout:      50 * time.Millisecond,
+			wantFrom:     "secondary",
+			wantHedged:   true,
+			wantHedgeReq: 1,
+		},
+		{
+			name:         "failed hedge waits for the primary",
+			primarySlow:  true,
+			secondary:    []allocatortest.Behavior{allocatortest.Fail(codes.Unavailable, "down")},
+			wantFrom:     "primary",
+			wantHedged:   true,
+			wantHedgeReq: 1,
+		},
+		{
+			name:    "a primary that fails before the delay is not hedged",
+			primary: []allocatortest.Behavior{allocatortest.Fail(codes.Internal, "oops")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary, c := newFailoverPair(t, readyGameServers("gs-1")...)
+			if tt.primarySlow {
+				primary.Script(allocatortest.Delay(200*time.Millisecond, primary.AllocateFromFleet))
+			}
+			if len(tt.primary) > 0 {
+				primary.Script(tt.primary...)
+			}
+			secondary.Script(tt.secondary...)
+			servers := map[string]*allocatortest.Server{"primary": primary, "secondary": secondary}
+
+			var mu sync.Mutex
+			extra := []*Allocation{}
+			c.Hedge = &Hedge{
+				Delay:   50 * time.Millisecond,
+				Timeout: tt.timeout,
+				Extra: func(a *Allocation) {
+					mu.Lock()
+					defer mu.Unlock()
+					extra = append(extra, a)
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			allocation, err := c.AllocateGameserverWithRetryContext(ctx)
+			if tt.cancel {
+				cancel()
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, secondary.Requests())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, servers[tt.wantFrom].Address, allocation.Endpoint)
+			assert.Equal(t, tt.wantHedged, allocation.Hedged)
+			assert.Equal(t, 1, allocation.Attempts)
+
+			wait, stop := context.WithTimeout(context.Background(), 5*time.Second)
+			defer stop()
+			require.NoError(t, c.WaitForHedges(wait))
+			assert.Len(t, secondary.Requests(), tt.wantHedgeReq)
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.wantExtra {
+				require.Len(t, extra, 1)
+				assert.Equal(t, primary.Address, extra[0].Endpoint)
+				assert.Equal(t, "gs-1", extra[0].GameServerName)
+			} else {
+				assert.Empty(t, extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+)
+
+// Invoker sends an allocation request on, either to the next interceptor or to the allocator
+type Invoker func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error)
+
+// Interceptor wraps every allocation request the client sends, including each retry. It can
+// change the request before calling next, look at or replace the response, or fail the
+// request without calling next at all. Use EndpointFromContext to see where the request is going.
+type Interceptor func(ctx context.Context, request *pb.AllocationRequest, next Invoker) (*pb.AllocationResponse, error)
+
+// AttemptStart describes an allocation attempt that is about to be made
+type AttemptStart struct {
+	Endpoint string
+	// Number counts the attempts for one allocation, starting at 1. It is zero for
+	// requests that are never retried, such as benchmarks.
+	Number int
+}
+
+// Retry describes a failed attempt that is about to be retried
+type Retry struct {
+	// Number is the number of the attempt that will be made next
+	Number int
+	// Delay is how long the client waits before retrying
+	Delay time.Duration
+	// Err is why the last attempt failed
+	Err error
+}
+
+type endpointKey struct{}
+
+// EndpointFromContext returns the endpoint that an intercepted request is being sent to
+func EndpointFromContext(ctx context.Context) string {
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// intercept runs the request through the client's interceptors, with the first one outermost,
+// and then the invoker
+func (c *Client) intercept(ctx context.Context, endpoint string, request *pb.AllocationRequest, invoke Invoker) (*pb.AllocationResponse, error) {
+	if len(c.Interceptors) == 0 {
+		return invoke(ctx, request)
+	}
+	ctx = context.WithValue(ctx, endpointKey{}, endpoint)
+	next := invoke
+	for i := len(c.Interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := c.Interceptors[i], next
+		next = func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+			return interceptor(ctx, request, inner)
+		}
+	}
+	return next(ctx, request)
+}
+
+// copyMetaPatch returns a copy of the patch, so that one request can be changed without
+// changing the client's settings
+func copyMetaPatch(patch *pb.MetaPatch) *pb.MetaPatch {
+	if patch == nil {
+		return nil
+	}
+	copied := &pb.MetaPatch{}
+	if patch.Labels != nil {
+		copied.Labels = make(map[string]string, len(patch.Labels))
+		for k, v := range patch.Labels {
+			copied.Labels[k] = v
+		}
+	}
+	if patch.Annotations != nil {
+		copied.Annotations = make(map[string]string, len(patch.Annotations))
+		for k, v := range patch.Annotations {
+			copied.Annotations[k] = v
+		}
+	}
+	return copied
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocatortest"
+)
+
+func TestClient_intercept(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, request *pb.AllocationRequest, next Invoker) (*pb.AllocationResponse, error) {
+			calls = append(calls, name+" before "+EndpointFromContext(ctx))
+			response, err := next(ctx, request)
+			calls = append(calls, name+" after")
+			return response, err
+		}
+	}
+	invoke := func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		calls = append(calls, "invoke")
+		return &pb.AllocationResponse{GameServerName: "gs-1"}, nil
+	}
+
+	t.Run("no interceptors", func(t *testing.T) {
+		calls = nil
+		c := &Client{}
+		response, err := c.intercept(context.Background(), "a:443", &pb.AllocationRequest{}, invoke)
+		require.NoError(t, err)
+		assert.Equal(t, "gs-1", response.GameServerName)
+		assert.Equal(t, []string{"invoke"}, calls)
+	})
+
+	t.Run("first interceptor is outermost", func(t *testing.T) {
+		calls = nil
+		c := &Client{Interceptors: []Interceptor{record("one"), record("two")}}
+		_, err := c.intercept(context.Background(), "a:443", &pb.AllocationRequest{}, invoke)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one before a:443", "two before a:443", "invoke", "two after", "one after"}, calls)
+	})
+
+	t.Run("short circuit", func(t *testing.T) {
+		calls = nil
+		deny := func(ctx context.Context, request *pb.AllocationRequest, next Invoker) (*pb.AllocationResponse, error) {
+			return nil, status.Error(codes.PermissionDenied, "not today")
+		}
+		c := &Client{Interceptors: []Interceptor{record("one"), deny, record("two")}}
+		_, err := c.intercept(context.Background(), "a:443", &pb.AllocationRequest{}, invoke)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, []string{"one before a:443", "one after"}, calls)
+	})
+}
+
+func TestClient_Interceptors(t *testing.T) {
+	server := newFakeAllocator(t, nil)
+	server.AddGameServers(readyGameServers("gs-1")...)
+	c := newFakeClient(t, server)
+	c.MetaPatch = &pb.MetaPatch{Labels: map[string]string{"player": "one"}}
+	c.Interceptors = []Interceptor{
+		func(ctx context.Context, request *pb.AllocationRequest, next Invoker) (*pb.AllocationResponse, error) {
+			request.MetaPatch.Labels["session"] = "abc"
+			return next(ctx, request)
+		},
+	}
+
+	_, err := c.AllocateGameserverWithRetry()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"player": "one", "session": "abc"}, server.Requests()[0].Request.MetaPatch.Labels)
+	assert.Equal(t, map[string]string{"player": "one"}, c.MetaPatch.Labels, "the client's MetaPatch is not changed")
+}
+
+func TestClient_hooks(t *testing.T) {
+	primary, secondary, c := newFailoverPair(t, readyGameServers("gs-1")...)
+	primary.Script(allocatortest.Fail(codes.Unavailable, "down"))
+	c.MaxRetries = 1
+
+	var events []string
+	c.BeforeAttempt = func(a AttemptStart) {
+		events = append(events, fmt.Sprintf("before %d %s", a.Number, a.Endpoint))
+	}
+	c.OnAttempt = func(a Attempt) {
+		events = append(events, fmt.Sprintf("after %d %s %t", a.Number, a.Endpoint, a.Err == nil))
+	}
+	c.OnRetry = func(r Retry) {
+		assert.Error(t, r.Err)
+		assert.Greater(t, int64(r.Delay), int64(0))
+		events = append(events, fmt.Sprintf("retry %d", r.Number))
+	}
+	c.OnEndpointChange = func(from, to string) {
+		events = append(events, fmt.Sprintf("endpoint %s %s", from, to))
+	}
+
+	_, err := c.AllocateGameserverWithRetry()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"before 1 " + primary.Address,
+		"after 1 " + primary.Address + " false",
+		"retry 2",
+		"endpoint " + primary.Address + " " + secondary.Address,
+		"before 2 " + secondary.Address,
+		"after 2 " + secondary.Address + " true",
+	}, events)
+}
+
+func TestClient_hooks_bench(t *testing.T) {
+	certs, err := allocatortest.NewCertificates()
+	require.NoError(t, err)
+	server := newFakeAllocator(t, certs)
+	server.AddGameServers(readyGameServers("gs-1")...)
+	server.Script(allocatortest.Fail(codes.ResourceExhausted, "no gameservers"))
+	c := newFakeClient(t, server)
+
+	var events []string
+	c.BeforeAttempt = func(a AttemptStart) {
+		events = append(events, fmt.Sprintf("before %d %s", a.Number, a.Endpoint))
+	}
+	c.OnAttempt = func(a Attempt) {
+		events = append(events, fmt.Sprintf("after %d %s %t", a.Number, a.Endpoint, a.Err == nil))
+	}
+
+	report := NewBenchReport()
+	require.NoError(t, c.RunBench(context.Background(), BenchOptions{Requests: 2}, report))
+	assert.Equal(t, []string{
+		"before 0 " + server.Address,
+		"after 0 " + server.Address + " false",
+		"before 0 " + server.Address,
+		"after 0 " + server.Address + " true",
+	}, events)
+}
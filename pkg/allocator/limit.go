@@ -0,0 +1,210 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// Limit caps how fast, and how many at once, allocation requests are sent
+type Limit struct {
+	// Rate is the number of requests per second. Zero means no rate limit.
+	Rate float64
+	// Burst is how many requests can be sent back to back before Rate applies. It defaults to 1.
+	Burst int
+	// MaxInFlight is the most requests waiting on a response at once. Zero means no limit.
+	MaxInFlight int
+}
+
+// Limits keep the client from tripping the allocator's own throttling. Requests that are
+// over a limit wait in the client, and that wait is reported apart from the allocator latency.
+type Limits struct {
+	// Global applies to every request the client sends
+	Global Limit
+	// Endpoints apply to the requests sent to one endpoint, on top of Global. Endpoints without a
+	// port are assumed to use 443, the same as in Endpoints.
+	Endpoints map[string]Limit
+}
+
+// Validate checks that none of the limits are negative
+func (l *Limits) Validate() error {
+	if err := l.Global.validate(); err != nil {
+		return err
+	}
+	for endpoint, limit := range l.Endpoints {
+		if err := limit.validate(); err != nil {
+			return fmt.Errorf("%s - %s", endpoint, err.Error())
+		}
+	}
+	return nil
+}
+
+func (l Limit) validate() error {
+	if l.Rate < 0 || l.Burst < 0 || l.MaxInFlight < 0 {
+		return fmt.Errorf("rate, burst and max in flight cannot be negative")
+	}
+	return nil
+}
+
+// endpoint returns the limit for an endpoint, if it has one
+func (l *Limits) endpoint(endpoint string) (Limit, bool) {
+	for name, limit := range l.Endpoints {
+		if withDefaultPort(name) == withDefaultPort(endpoint) {
+			return limit, true
+		}
+	}
+	return Limit{}, false
+}
+
+// limiter is a token bucket and a semaphore for one Limit
+type limiter struct {
+	limit    Limit
+	burst    float64
+	inFlight chan struct{}
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(limit Limit) *limiter {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	l := &limiter{
+		limit:  limit,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+	if limit.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limit.MaxInFlight)
+	}
+	return l
+}
+
+// reserve takes a token and returns how long to wait before using it. The bucket can go
+// into debt, so that waiting requests are let through in order at the rate.
+func (l *limiter) reserve() time.Duration {
+	if l.limit.Rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limit.Rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+}
+
+// unreserve gives back a token that was never used
+func (l *limiter) unreserve() {
+	if l.limit.Rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
+
+// acquire waits for a slot and a token, and returns a function that gives the slot back
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	release := func() {}
+	if l.inFlight != nil {
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case l.inFlight <- struct{}{}:
+		}
+		release = func() { <-l.inFlight }
+	}
+	if err := sleepContext(ctx, l.reserve()); err != nil {
+		l.unreserve()
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// limiter returns the limiter for a key, making a new one if the limit has changed
+func (c *Client) limiter(key string, limit Limit) *limiter {
+	c.limitersMu.Lock()
+	defer c.limitersMu.Unlock()
+	if c.limiters == nil {
+		c.limiters = make(map[string]*limiter)
+	}
+	l, ok := c.limiters[key]
+	if !ok || l.limit != limit {
+		l = newLimiter(limit)
+		c.limiters[key] = l
+	}
+	return l
+}
+
+// waitForLimits blocks until the client's limits allow a request to the endpoint. It returns
+// how long the request waited, and a function to call once the request is done. The endpoint's
+// limit is waited for first, so that requests queued for a busy endpoint do not hold global
+// slots and tokens that requests to other endpoints could use.
+func (c *Client) waitForLimits(ctx context.Context, endpoint string) (func(), time.Duration, error) {
+	if c.Limits == nil {
+		return func() {}, 0, nil
+	}
+	limiters := []*limiter{}
+	if limit, ok := c.Limits.endpoint(endpoint); ok {
+		limiters = append(limiters, c.limiter(endpoint, limit))
+	}
+	limiters = append(limiters, c.limiter("", c.Limits.Global))
+
+	start := time.Now()
+	releases := make([]func(), 0, len(limiters))
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	for _, l := range limiters {
+		r, err := l.acquire(ctx)
+		if err != nil {
+			release()
+			return nil, time.Since(start), err
+		}
+		releases = append(releases, r)
+	}
+	return release, time.Since(start), nil
+}
+
+// withDefaultPort adds port 443 to an endpoint that has no port
+func withDefaultPort(endpoint string) string {
+	if !strings.Contains(endpoint, ":") {
+		return fmt.Sprintf("%s:443", endpoint)
+	}
+	return endpoint
+}
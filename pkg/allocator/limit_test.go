@@ -0,0 +1,139 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_limiter_reserve(t *testing.T) {
+	l := newLimiter(Limit{Rate: 10, Burst: 2})
+	l.last = time.Now()
+	assert.Equal(t, time.Duration(0), l.reserve())
+	assert.Equal(t, time.Duration(0), l.reserve())
+	// The bucket is empty, so the next requests are spaced out at the rate
+	assert.InDelta(t, float64(100*time.Millisecond), float64(l.reserve()), float64(5*time.Millisecond))
+	assert.InDelta(t, float64(200*time.Millisecond), float64(l.reserve()), float64(5*time.Millisecond))
+	l.unreserve()
+	assert.InDelta(t, float64(200*time.Millisecond), float64(l.reserve()), float64(5*time.Millisecond))
+
+	unlimited := newLimiter(Limit{})
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), unlimited.reserve())
+	}
+}
+
+func Test_limiter_acquire(t *testing.T) {
+	l := newLimiter(Limit{MaxInFlight: 1})
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	release()
+	release, err = l.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestClient_waitForLimits(t *testing.T) {
+	c := &Client{Limits: &Limits{
+		Global:    Limit{MaxInFlight: 2},
+		Endpoints: map[string]Limit{"busy": {MaxInFlight: 1}},
+	}}
+	release, _, err := c.waitForLimits(context.Background(), "busy:443")
+	require.NoError(t, err)
+
+	// A second request to the busy endpoint queues without taking a global slot
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+	go func() {
+		_, _, err := c.waitForLimits(ctx, "busy:443")
+		queued <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	wait, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	other, _, err := c.waitForLimits(wait, "other:443")
+	require.NoError(t, err, "the other endpoint gets the free global slot")
+	other()
+
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(<-queued))
+	release()
+}
+
+func TestLimits(t *testing.T) {
+	limits := &Limits{Endpoints: map[string]Limit{"east": {Rate: 1}, "west:8443": {MaxInFlight: 2}}}
+	limit, ok := limits.endpoint("east:443")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Rate: 1}, limit)
+	limit, ok = limits.endpoint("east")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Rate: 1}, limit)
+	_, ok = limits.endpoint("west:443")
+	assert.False(t, ok)
+	assert.NoError(t, limits.Validate())
+
+	limits.Endpoints["west:8443"] = Limit{MaxInFlight: -1}
+	assert.Error(t, limits.Validate())
+}
+
+func TestClient_Limits(t *testing.T) {
+	server := newFakeAllocator(t, nil)
+	server.AddGameServers(readyGameServers("gs-1", "gs-2", "gs-3", "gs-4")...)
+	server.SetLatency(50 * time.Millisecond)
+	c := newFakeClient(t, server)
+	c.Limits = &Limits{Endpoints: map[string]Limit{server.Address: {MaxInFlight: 1}}}
+
+	var mu sync.Mutex
+	attempts := []Attempt{}
+	c.OnAttempt = func(a Attempt) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, a)
+	}
+
+	c.Concurrency = 4
+	results := c.AllocateMany(context.Background(), 4)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+	require.Len(t, attempts, 4)
+	var maxWait time.Duration
+	for _, a := range attempts {
+		// The allocator latency does not include the time spent waiting in the client
+		assert.Less(t, int64(a.Latency), int64(150*time.Millisecond))
+		if a.QueueWait > maxWait {
+			maxWait = a.QueueWait
+		}
+	}
+	// One request at a time means the last one waited for the other three
+	assert.GreaterOrEqual(t, int64(maxWait), int64(140*time.Millisecond))
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
+)
+
+const (
+	// DefaultRerankSmoothing is the weight of the newest ping in an endpoint's score by default
+	DefaultRerankSmoothing = 0.3
+	// DefaultRerankHysteresis is how much faster another endpoint has to be to switch to it by default
+	DefaultRerankHysteresis = 0.2
+)
+
+// RerankOptions configure how RerankEndpoints scores the endpoints
+type RerankOptions struct {
+	// Interval is how often the ping servers are pinged
+	Interval time.Duration
+	// Smoothing is the weight, more than 0 and at most 1, of the newest ping in an endpoint's
+	// score. The score is a moving average, so lower values ride out short spikes. Left at
+	// zero, RerankEndpoints uses DefaultRerankSmoothing.
+	Smoothing float64
+	// Hysteresis is how much faster, as a fraction of the current endpoint's score, another
+	// endpoint has to be before the client switches to it, e.g. 0.2 for 20% faster. It keeps
+	// the client from flapping between endpoints that are about as fast, so it cannot be
+	// turned off: it must be more than 0 and less than 1. Left at zero, RerankEndpoints uses
+	// DefaultRerankHysteresis.
+	Hysteresis float64
+}
+
+// withDefaults returns the options with the defaults filled in for the fields left at zero
+func (o RerankOptions) withDefaults() RerankOptions {
+	if o.Smoothing == 0 {
+		o.Smoothing = DefaultRerankSmoothing
+	}
+	if o.Hysteresis == 0 {
+		o.Hysteresis = DefaultRerankHysteresis
+	}
+	return o
+}
+
+// Validate checks that the interval is positive and the fractions are in range. Zero is not a
+// valid smoothing or hysteresis, so options from flags should be validated as they are.
+func (o RerankOptions) Validate() error {
+	if o.Interval <= 0 {
+		return fmt.Errorf("the rerank interval must be more than zero")
+	}
+	if o.Smoothing <= 0 || o.Smoothing > 1 {
+		return fmt.Errorf("rerank smoothing must be more than 0 and at most 1")
+	}
+	if o.Hysteresis <= 0 || o.Hysteresis >= 1 {
+		return fmt.Errorf("rerank hysteresis must be more than 0 and less than 1")
+	}
+	return nil
+}
+
+// Scores returns the smoothed ping time of each reachable endpoint, by endpoint. It is empty
+// unless the client was made with ping servers.
+func (c *Client) Scores() map[string]time.Duration {
+	c.endpointMu.RLock()
+	defer c.endpointMu.RUnlock()
+	scores := make(map[string]time.Duration, len(c.scores))
+	for endpoint, score := range c.scores {
+		scores[endpoint] = score
+	}
+	return scores
+}
+
+// RerankEndpoints pings the ping servers on every interval until the context is done, and
+// switches to another endpoint when it is clearly faster than the current one. Endpoints that
+// were dropped because their ping server did not answer are added back once it does. It only
+// does anything for clients made with ping servers. Smoothing and Hysteresis left at zero are
+// set to their defaults.
+func (c *Client) RerankEndpoints(ctx context.Context, options RerankOptions) error {
+	options = options.withDefaults()
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	c.endpointMu.RLock()
+	pingHosts := make(map[string]string, len(c.pingHosts))
+	for endpoint, pingServer := range c.pingHosts {
+		pingHosts[endpoint] = pingServer
+	}
+	c.endpointMu.RUnlock()
+	if len(pingHosts) == 0 {
+		return fmt.Errorf("the client has no ping servers to rerank by")
+	}
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.rerank(measurePings(pingHosts), options)
+		}
+	}
+}
+
+// rerank updates the scores with a round of ping times, by endpoint, and switches endpoints
+// if another one is faster by more than the hysteresis
+func (c *Client) rerank(times map[string]time.Duration, options RerankOptions) {
+	if len(times) == 0 {
+		// Losing every ping server at once is more likely a problem on this end, so keep
+		// things as they are
+		klog.Warning("no ping servers answered - keeping the current endpoints")
+		return
+	}
+
+	c.endpointMu.Lock()
+	for endpoint, pingServer := range c.pingHosts {
+		key := withDefaultPort(endpoint)
+		latest, ok := times[endpoint]
+		if !ok {
+			if _, listed := c.Endpoints[endpoint]; listed {
+				klog.V(2).Infof("%s did not answer - dropping %s", pingServer, endpoint)
+				delete(c.Endpoints, endpoint)
+			}
+			delete(c.scores, key)
+			continue
+		}
+		if _, listed := c.Endpoints[endpoint]; !listed {
+			klog.V(2).Infof("%s answers again - adding %s back", pingServer, endpoint)
+			c.Endpoints[endpoint] = pingServer
+		}
+		if score, scored := c.scores[key]; scored {
+			c.scores[key] = time.Duration(options.Smoothing*float64(latest) + (1-options.Smoothing)*float64(score))
+		} else {
+			c.scores[key] = latest
+		}
+	}
+
+	current := withDefaultPort(c.Endpoint)
+	currentScore, reachable := c.scores[current]
+	fastest := c.fastest()
+	switchTo := ""
+	if fastest != current && (!reachable || float64(c.scores[fastest]) < float64(currentScore)*(1-options.Hysteresis)) {
+		switchTo = fastest
+	}
+	c.endpointMu.Unlock()
+
+	if switchTo != "" {
+		klog.V(2).Infof("%s is now the fastest endpoint - switching from %s", switchTo, current)
+		c.setEndpoint(switchTo)
+	}
+}
+
+// fastest returns the endpoint with the lowest score. Ties go to the first by name.
+// c.endpointMu must be held.
+func (c *Client) fastest() string {
+	fastest := ""
+	for endpoint, score := range c.scores {
+		if fastest == "" || score < c.scores[fastest] || (score == c.scores[fastest] && endpoint < fastest) {
+			fastest = endpoint
+		}
+	}
+	return fastest
+}
+
+// rankedEndpoints returns the client's endpoints in the order they should be tried: the
+// current endpoint first, then the others by score, and by name for those without one
+func (c *Client) rankedEndpoints() []string {
+	c.endpointMu.RLock()
+	defer c.endpointMu.RUnlock()
+	preferred := withDefaultPort(c.Endpoint)
+	others := make([]string, 0, len(c.Endpoints))
+	for endpoint := range c.Endpoints {
+		if endpoint = withDefaultPort(endpoint); endpoint != preferred {
+			others = append(others, endpoint)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		a, aScored := c.scores[others[i]]
+		b, bScored := c.scores[others[j]]
+		if aScored != bScored {
+			return aScored
+		}
+		if a != b {
+			return a < b
+		}
+		return others[i] < others[j]
+	})
+	return append([]string{preferred}, others...)
+}
+
+// measurePings pings the ping server of each endpoint, and returns the response times of the
+// ones that answered, by endpoint
+func measurePings(pingHosts map[string]string) map[string]time.Duration {
+	times := make(map[string]time.Duration, len(pingHosts))
+	for endpoint, pingServer := range pingHosts {
+		klog.V(2).Infof("checking ping for server: %s ping: %s", endpoint, pingServer)
+		trace := ping.Trace{
+			Host: pingServer,
+		}
+		err := trace.Run()
+		if err != nil {
+			klog.V(3).Infof("trace failed on %s - %s", pingServer, err.Error())
+			continue
+		}
+		times[endpoint] = trace.ResponseTime
+	}
+	return times
+}
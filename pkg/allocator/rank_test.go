@@ -0,0 +1,217 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/ping"
+)
+
+func TestClient_rerank(t *testing.T) {
+	ms := time.Millisecond
+	options := RerankOptions{Interval: time.Second, Smoothing: 0.5, Hysteresis: 0.2}
+	newClient := func() *Client {
+		return &Client{
+			Endpoint:  "east:443",
+			Endpoints: map[string]string{"east:443": "east-ping", "west:443": "west-ping"},
+			pingHosts: map[string]string{"east:443": "east-ping", "west:443": "west-ping", "central:443": "central-ping"},
+			scores:    map[string]time.Duration{"east:443": 100 * ms, "west:443": 120 * ms},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		rounds        []map[string]time.Duration
+		wantEndpoint  string
+		wantScores    map[string]time.Duration
+		wantEndpoints []string
+	}{
+		{
+			name:          "scores are smoothed",
+			rounds:        []map[string]time.Duration{{"east:443": 200 * ms, "west:443": 120 * ms}},
+			wantEndpoint:  "east:443",
+			wantScores:    map[string]time.Duration{"east:443": 150 * ms, "west:443": 120 * ms},
+			wantEndpoints: []string{"east:443", "west:443"},
+		},
+		{
+			name: "a slightly faster endpoint is not worth switching to",
+			rounds: []map[string]time.Duration{
+				{"east:443": 100 * ms, "west:443": 90 * ms},
+				{"east:443": 100 * ms, "west:443": 90 * ms},
+			},
+			wantEndpoint:  "east:443",
+			wantScores:    map[string]time.Duration{"east:443": 100 * ms, "west:443": 97500 * time.Microsecond},
+			wantEndpoints: []string{"east:443", "west:443"},
+		},
+		{
+			name: "a clearly faster endpoint is switched to",
+			rounds: []map[string]time.Duration{
+				{"east:443": 100 * ms, "west:443": 40 * ms},
+				{"east:443": 100 * ms, "west:443": 40 * ms},
+			},
+			wantEndpoint:  "west:443",
+			wantScores:    map[string]time.Duration{"east:443": 100 * ms, "west:443": 60 * ms},
+			wantEndpoints: []string{"east:443", "west:443"},
+		},
+		{
+			name:          "unreachable endpoints are dropped and the current one is replaced",
+			rounds:        []map[string]time.Duration{{"west:443": 120 * ms}},
+			wantEndpoint:  "west:443",
+			wantScores:    map[string]time.Duration{"west:443": 120 * ms},
+			wantEndpoints: []string{"west:443"},
+		},
+		{
+			name: "dropped endpoints come back once they answer",
+			rounds: []map[string]time.Duration{
+				{"east:443": 100 * ms, "west:443": 120 * ms, "central:443": 500 * ms},
+			},
+			wantEndpoint:  "east:443",
+			wantScores:    map[string]time.Duration{"east:443": 100 * ms, "west:443": 120 * ms, "central:443": 500 * ms},
+			wantEndpoints: []string{"central:443", "east:443", "west:443"},
+		},
+		{
+			name:          "nothing changes when no ping server answers",
+			rounds:        []map[string]time.Duration{{}},
+			wantEndpoint:  "east:443",
+			wantScores:    map[string]time.Duration{"east:443": 100 * ms, "west:443": 120 * ms},
+			wantEndpoints: []string{"east:443", "west:443"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient()
+			for _, round := range tt.rounds {
+				c.rerank(round, options)
+			}
+			assert.Equal(t, tt.wantEndpoint, c.CurrentEndpoint())
+			assert.Equal(t, tt.wantScores, c.Scores())
+			endpoints := []string{}
+			for endpoint := range c.CurrentEndpoints() {
+				endpoints = append(endpoints, endpoint)
+			}
+			assert.ElementsMatch(t, tt.wantEndpoints, endpoints)
+		})
+	}
+}
+
+func TestRerankOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options RerankOptions
+		wantErr bool
+	}{
+		{name: "valid", options: RerankOptions{Interval: time.Second, Smoothing: 1, Hysteresis: 0.2}},
+		{name: "no interval", options: RerankOptions{Smoothing: 0.3, Hysteresis: 0.2}, wantErr: true},
+		{name: "zero smoothing", options: RerankOptions{Interval: time.Second, Hysteresis: 0.2}, wantErr: true},
+		{name: "smoothing above 1", options: RerankOptions{Interval: time.Second, Smoothing: 1.5, Hysteresis: 0.2}, wantErr: true},
+		{name: "zero hysteresis", options: RerankOptions{Interval: time.Second, Smoothing: 0.3}, wantErr: true},
+		{name: "hysteresis of 1", options: RerankOptions{Interval: time.Second, Smoothing: 0.3, Hysteresis: 1}, wantErr: true},
+		{name: "zero fields are defaulted", options: RerankOptions{Interval: time.Second}.withDefaults()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestClient_rankedEndpoints(t *testing.T) {
+	c := &Client{
+		Endpoint:  "west:443",
+		Endpoints: map[string]string{"east": "", "west:443": "", "central:443": "", "north:443": ""},
+		scores:    map[string]time.Duration{"central:443": 50 * time.Millisecond, "east:443": 20 * time.Millisecond},
+	}
+	assert.Equal(t, []string{"west:443", "east:443", "central:443", "north:443"}, c.rankedEndpoints())
+}
+
+// switchable serves pings with a latency that can be changed, or drops them while lost is set
+type switchable struct {
+	latency int64
+	lost    int32
+}
+
+func (s *switchable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.lost) == 1 {
+		ping.NewServer(ping.Faults{Loss: 1}).ServeHTTP(w, r)
+		return
+	}
+	ping.NewServer(ping.Faults{Latency: time.Duration(atomic.LoadInt64(&s.latency))}).ServeHTTP(w, r)
+}
+
+func Test_measurePings_again(t *testing.T) {
+	server := httptest.NewServer(ping.Handler())
+	defer server.Close()
+	pingHosts := map[string]string{"east:443": server.URL}
+
+	for i := 0; i < 3; i++ {
+		times := measurePings(pingHosts)
+		require.Contains(t, times, "east:443")
+		assert.Less(t, int64(times["east:443"]), int64(time.Second), "round %d", i)
+	}
+}
+
+func TestClient_RerankEndpoints(t *testing.T) {
+	near := &switchable{latency: int64(5 * time.Millisecond)}
+	far := &switchable{latency: int64(80 * time.Millisecond)}
+	gone := &switchable{lost: 1}
+	servers := map[string]*switchable{"near.example.com:443": near, "far.example.com:443": far, "gone.example.com:443": gone}
+	endpoints := map[string]string{}
+	for endpoint, handler := range servers {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		endpoints[endpoint] = server.URL
+	}
+
+	c := &Client{Endpoints: endpoints}
+	require.NoError(t, c.setEndpointByPing())
+	assert.Equal(t, "near.example.com:443", c.CurrentEndpoint())
+	assert.NotContains(t, c.Scores(), "gone.example.com:443")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- c.RerankEndpoints(ctx, RerankOptions{Interval: 10 * time.Millisecond, Smoothing: 1})
+	}()
+
+	atomic.StoreInt64(&near.latency, int64(200*time.Millisecond))
+	atomic.StoreInt32(&gone.lost, 0)
+	assert.Eventually(t, func() bool {
+		scores := c.Scores()
+		_, back := scores["gone.example.com:443"]
+		return c.CurrentEndpoint() != "near.example.com:443" && back
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Error(t, (&Client{}).RerankEndpoints(context.Background(), RerankOptions{Interval: time.Second}), "no ping servers")
+	assert.Error(t, c.RerankEndpoints(context.Background(), RerankOptions{}), "no interval")
+}
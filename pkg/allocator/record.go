@@ -0,0 +1,190 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// Record is a single allocation request and its outcome
+type Record struct {
+	Time     time.Time              `json:"time"`
+	Endpoint string                 `json:"endpoint"`
+	Request  *pb.AllocationRequest  `json:"request"`
+	Response *pb.AllocationResponse `json:"response,omitempty"`
+	Code     string                 `json:"code"`
+	Error    string                 `json:"error,omitempty"`
+	Latency  time.Duration          `json:"latency"`
+}
+
+// Recorder writes every allocation request a Client makes as JSON lines
+type Recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+	closed  bool
+}
+
+// NewRecorder returns a recorder that writes to w. Call Close when done, so that a file is
+// synced and closed.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Close syncs the writer to disk if it is a file, and closes it if it can be closed.
+// Requests made after Close are not recorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if f, ok := r.w.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if closer, ok := r.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// record writes a request and its outcome. A failure to record is logged rather than
+// returned so that it never fails the allocation itself.
+func (r *Recorder) record(start time.Time, endpoint string, request *pb.AllocationRequest, response *pb.AllocationResponse, err error) {
+	rec := Record{
+		Time:     start,
+		Endpoint: endpoint,
+		Request:  request,
+		Response: response,
+		Code:     status.Code(err).String(),
+		Latency:  time.Since(start),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if encodeErr := r.encoder.Encode(rec); encodeErr != nil {
+		klog.Errorf("could not record allocation request - %s", encodeErr.Error())
+	}
+}
+
+// ReadRecords reads a stream of JSON lines written by a Recorder, sorted by time
+func ReadRecords(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := Record{}
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if rec.Request == nil {
+			return nil, fmt.Errorf("line %d: record has no request", line)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// ReplayOptions configures a replay of recorded traffic
+type ReplayOptions struct {
+	// Speed scales the original timing. 2 replays the traffic twice as fast. Zero
+	// sends every request immediately.
+	Speed float64
+	// Namespace, if set, replaces the namespace of every recorded request
+	Namespace string
+}
+
+// Replay re-issues recorded allocation requests against the client's endpoint, keeping the
+// gaps between them as they were recorded, scaled by the speed. Each request is made once,
+// without retries, and the results are collected in the same way as RunBench.
+func (c *Client) Replay(ctx context.Context, records []Record, opts ReplayOptions, report *BenchReport) error {
+	if len(records) == 0 {
+		return fmt.Errorf("there are no records to replay")
+	}
+	first := records[0].Time
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range records {
+		if opts.Speed > 0 {
+			offset := time.Duration(float64(records[i].Time.Sub(first)) / opts.Speed)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		}
+		if ctx.Err() != nil {
+			klog.V(2).Infof("replay stopped - %d of %d requests not sent", len(records)-i, len(records))
+			break
+		}
+
+		request := records[i].Request
+		if opts.Namespace != "" {
+			request = &pb.AllocationRequest{
+				Namespace:                    opts.Namespace,
+				MultiClusterSetting:          request.MultiClusterSetting,
+				RequiredGameServerSelector:   request.RequiredGameServerSelector,
+				PreferredGameServerSelectors: request.PreferredGameServerSelectors,
+				Scheduling:                   request.Scheduling,
+				MetaPatch:                    request.MetaPatch,
+			}
+		}
+		wg.Add(1)
+		go func(request *pb.AllocationRequest) {
+			defer wg.Done()
+			c.benchRequest(ctx, request, report)
+		}(request)
+	}
+	wg.Wait()
+	report.finish()
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecorder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder := NewRecorder(buf)
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	request := &pb.AllocationRequest{
+		Namespace: "default",
+		RequiredGameServerSelector: &pb.LabelSelector{
+			MatchLabels: map[string]string{"region": "east"},
+		},
+	}
+
+	recorder.record(start.Add(time.Second), "east:443", request, nil, status.Error(codes.ResourceExhausted, "no gameservers"))
+	recorder.record(start, "east:443", request, &pb.AllocationResponse{GameServerName: "gs-1", Address: "10.0.0.1"}, nil)
+
+	records, err := ReadRecords(buf)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// Records come back in time order
+	assert.True(t, records[0].Time.Equal(start))
+	assert.Equal(t, "OK", records[0].Code)
+	assert.Equal(t, "gs-1", records[0].Response.GameServerName)
+	assert.Equal(t, "east", records[0].Request.RequiredGameServerSelector.MatchLabels["region"])
+
+	assert.Equal(t, "ResourceExhausted", records[1].Code)
+	assert.Contains(t, records[1].Error, "no gameservers")
+	assert.Nil(t, records[1].Response)
+}
+
+func TestRecorder_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	recorder := NewRecorder(f)
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	recorder.record(start, "east:443", &pb.AllocationRequest{Namespace: "default"}, nil, nil)
+
+	require.NoError(t, recorder.Close())
+	assert.NoError(t, recorder.Close(), "closing again does nothing")
+	_, err = f.Write([]byte("more"))
+	assert.Error(t, err, "the file is closed")
+	recorder.record(start, "east:443", &pb.AllocationRequest{Namespace: "default"}, nil, nil)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	records, err := ReadRecords(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Len(t, records, 1, "requests after Close are not recorded")
+}
+
+func TestReadRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  0,
+		},
+		{
+			name:  "blank lines",
+			input: "{\"request\":{\"namespace\":\"default\"}}\n\n{\"request\":{}}\n",
+			want:  2,
+		},
+		{
+			name:    "invalid json",
+			input:   "{\"request\":\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing request",
+			input:   "{\"endpoint\":\"east:443\"}\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRecords(strings.NewReader(tt.input))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Replay(t *testing.T) {
+	server := newFakeAllocator(t, nil)
+	server.AddGameServers(readyGameServers("gs-1", "gs-2", "gs-3")...)
+	c := newFakeClient(t, server)
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, Request: &pb.AllocationRequest{Namespace: "team-a"}},
+		{Time: start, Request: &pb.AllocationRequest{Namespace: "team-b"}},
+		{Time: start, Request: &pb.AllocationRequest{}},
+	}
+
+	report := NewBenchReport()
+	require.NoError(t, c.Replay(context.Background(), records, ReplayOptions{}, report))
+	byNamespace := report.Summary().GameServersByNamespace()
+	assert.Len(t, byNamespace, 3)
+	for _, namespace := range []string{"team-a", "team-b", "default"} {
+		assert.Len(t, byNamespace[namespace], 1, namespace)
+	}
+
+	report = NewBenchReport()
+	server.Release("gs-1", "gs-2", "gs-3")
+	require.NoError(t, c.Replay(context.Background(), records, ReplayOptions{Namespace: "replay"}, report))
+	byNamespace = report.Summary().GameServersByNamespace()
+	assert.Len(t, byNamespace["replay"], 3, "replaced namespaces are where the gameservers are")
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// CertReloader keeps the client certificate and CA up to date with the files on disk,
+// so that a long-running Client keeps working when the certificates are rotated
+type CertReloader struct {
+	KeyFile  string
+	CertFile string
+	CAFile   string
+	// OnError is called whenever a reload fails. The previous certificates stay in use.
+	OnError func(error)
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	err      error
+	modTimes map[string]time.Time
+}
+
+// NewCertReloader loads the certificates for the first time. caFile may be empty to use the system roots.
+func NewCertReloader(keyFile, certFile, caFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		KeyFile:  keyFile,
+		CertFile: certFile,
+		CAFile:   caFile,
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificates from disk. If they can't be loaded, the previous ones are kept.
+func (r *CertReloader) Reload() error {
+	err := r.reload()
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+	if err != nil {
+		klog.Errorf("could not reload certificates - %s", err.Error())
+		if r.OnError != nil {
+			r.OnError(err)
+		}
+	}
+	return err
+}
+
+func (r *CertReloader) reload() error {
+	modTimes, err := r.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := ioutil.ReadFile(r.KeyFile)
+	if err != nil {
+		return err
+	}
+	certPEM, err := ioutil.ReadFile(r.CertFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		klog.Warningf("client certificate %s expired at %s", r.CertFile, leaf.NotAfter)
+	}
+
+	var roots *x509.CertPool
+	if r.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return errors.New("only PEM format is accepted for server CA")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.roots = roots
+	r.modTimes = modTimes
+	klog.V(2).Infof("loaded client certificate %s, valid until %s", r.CertFile, leaf.NotAfter)
+	return nil
+}
+
+func (r *CertReloader) currentModTimes() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.KeyFile, r.CertFile, r.CAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// changed returns true if any of the files have been modified since they were last loaded
+func (r *CertReloader) changed() bool {
+	modTimes, err := r.currentModTimes()
+	if err != nil {
+		// Let Reload report the error
+		return true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run checks the files for changes on an interval until the context is cancelled.
+// Files are reloaded when they change, and retried on every interval while they fail to load.
+func (r *CertReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.changed() || r.Err() != nil {
+				klog.V(2).Info("certificates changed on disk - reloading")
+				_ = r.Reload()
+			}
+		}
+	}
+}
+
+// Err returns the error from the last reload, or nil if it succeeded
+func (r *CertReloader) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// TLSConfig returns a TLS configuration that always uses the latest certificates
+func (r *CertReloader) TLSConfig() *tls.Config {
+	config := &tls.Config{
+		GetClientCertificate: r.getClientCertificate,
+	}
+	if r.CAFile != "" {
+		// The CA can change, so the server certificate is verified against the
+		// current pool here instead of a fixed RootCAs
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = r.verifyConnection
+	}
+	return config
+}
+
+func (r *CertReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not present a certificate")
+	}
+	r.mu.RLock()
+	roots := r.roots
+	r.mu.RUnlock()
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       state.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// UseCertReloader switches the client to certificates that are kept up to date by the reloader.
+// Set the Transport after calling this, since transports capture the TLS configuration.
+func (c *Client) UseCertReloader(r *CertReloader) {
+	c.setTLSConfig(r.TLSConfig())
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair writes a self-signed client certificate with the given common name
+func writeKeyPair(t *testing.T, keyFile, certFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func clientCommonName(t *testing.T, r *CertReloader) string {
+	cert, err := r.getClientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "tls.key")
+	certFile := filepath.Join(dir, "tls.crt")
+
+	writeKeyPair(t, keyFile, certFile, "first")
+	reloader, err := NewCertReloader(keyFile, certFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, "first", clientCommonName(t, reloader))
+	assert.False(t, reloader.changed())
+
+	var reported error
+	reloader.OnError = func(err error) { reported = err }
+
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("not a certificate"), 0600))
+	err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, err, reported)
+	assert.Equal(t, err, reloader.Err())
+	assert.Equal(t, "first", clientCommonName(t, reloader), "the previous certificate should stay in use")
+
+	writeKeyPair(t, keyFile, certFile, "second")
+	assert.NoError(t, reloader.Reload())
+	assert.NoError(t, reloader.Err())
+	assert.Equal(t, "second", clientCommonName(t, reloader))
+
+	_, err = NewCertReloader(filepath.Join(dir, "missing.key"), certFile, "")
+	assert.Error(t, err)
+}
+
+func TestCertReloader_verifyConnection(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "tls.key")
+	certFile := filepath.Join(dir, "tls.crt")
+	caFile := filepath.Join(dir, "ca.crt")
+	writeKeyPair(t, keyFile, certFile, "client")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	reloader, err := NewCertReloader(keyFile, certFile, caFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "trusted", serverName: "example.com"},
+		{name: "hostname mismatch", serverName: "other.example.org", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := reloader.TLSConfig()
+			config.ServerName = tt.serverName
+			conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			conn.Close()
+		})
+	}
+
+	// Once the CA is rotated, the old server certificate is no longer trusted
+	otherKey := filepath.Join(dir, "other.key")
+	writeKeyPair(t, otherKey, caFile, "other-ca")
+	require.NoError(t, reloader.Reload())
+	config := reloader.TLSConfig()
+	config.ServerName = "example.com"
+	_, err = tls.Dial("tcp", server.Listener.Addr().String(), config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,457 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// SessionResult is the way a single load-test session ended
+type SessionResult string
+
+const (
+	// SessionCompleted means the session ran for the full duration and said goodbye
+	SessionCompleted SessionResult = "completed"
+	// SessionInterrupted means the session was ended early, but cleanly, by a shutdown
+	SessionInterrupted SessionResult = "interrupted"
+	// SessionFailed means the session could not be allocated or could not talk to the gameserver
+	SessionFailed SessionResult = "failed"
+	// SessionAbandoned means the session did not finish within the shutdown grace period
+	SessionAbandoned SessionResult = "abandoned"
+)
+
+// LoadSummary is the result of a load test
+type LoadSummary struct {
+	Start               time.Time `json:"start"`
+	End                 time.Time `json:"end"`
+	Interrupted         bool      `json:"interrupted"`
+	SessionsStarted     int       `json:"sessionsStarted"`
+	Allocations         int       `json:"allocations"`
+	AllocationFailures  int       `json:"allocationFailures"`
+	SessionsCompleted   int       `json:"sessionsCompleted"`
+	SessionsInterrupted int       `json:"sessionsInterrupted"`
+	SessionsFailed      int       `json:"sessionsFailed"`
+	SessionsAbandoned   int       `json:"sessionsAbandoned"`
+	// Codes counts the gRPC response code of every allocation attempt, including retries
+	Codes map[string]int `json:"codes,omitempty"`
+	// Endpoints breaks the allocation attempts down by allocator endpoint
+	Endpoints map[string]EndpointSummary `json:"endpoints,omitempty"`
+	// QueueWait is the total time attempts spent waiting in the client for its Limits
+	QueueWait time.Duration `json:"queueWait,omitempty"`
+}
+
+// EndpointSummary counts the allocation attempts made to one endpoint
+type EndpointSummary struct {
+	Attempts int `json:"attempts"`
+	Errors   int `json:"errors"`
+	// CircuitOpened counts how many times the endpoint's circuit breaker opened
+	CircuitOpened int `json:"circuitOpened,omitempty"`
+}
+
+// Progress is a view of a running load test, with rates and latencies
+// calculated over a recent window of time
+type Progress struct {
+	Elapsed              time.Duration
+	Window               time.Duration
+	ActiveSessions       int
+	SessionsStarted      int
+	Allocations          int
+	AllocationsPerSecond float64
+	ErrorRate            float64
+	Codes                map[string]int
+	P50                  time.Duration
+	P90                  time.Duration
+	P99                  time.Duration
+	// QueueWaitP50 and QueueWaitP99 are how long attempts waited in the client for its Limits.
+	// They are not part of the latencies above.
+	QueueWaitP50 time.Duration
+	QueueWaitP99 time.Duration
+	Endpoints    map[string]EndpointProgress
+}
+
+// EndpointProgress is the recent activity against one endpoint
+type EndpointProgress struct {
+	Attempts int
+	Errors   int
+	P50      time.Duration
+	// Circuit is the state of the endpoint's circuit breaker, if the client has breakers
+	Circuit BreakerState
+}
+
+// attemptSample is kept for a short while to calculate Progress
+type attemptSample struct {
+	at        time.Time
+	endpoint  string
+	code      string
+	latency   time.Duration
+	queueWait time.Duration
+	failed    bool
+}
+
+// LoadReport collects the results of a load test. It is safe to read while the test is running.
+type LoadReport struct {
+	mu      sync.Mutex
+	summary LoadSummary
+	// closed is set once the run has finished. Sessions that were abandoned
+	// and finish afterwards should not change the report.
+	closed bool
+	// active is the number of sessions that have started but not finished
+	active int
+	// recent holds the attempts made within the last window
+	recent []attemptSample
+	window time.Duration
+	// breakers are the client's circuit breakers, if it has them
+	breakers *Breakers
+}
+
+// defaultProgressWindow is how far back Progress looks by default
+const defaultProgressWindow = 30 * time.Second
+
+// NewLoadReport returns an empty report with the start time set
+func NewLoadReport() *LoadReport {
+	return &LoadReport{
+		summary: LoadSummary{
+			Start:     time.Now(),
+			Codes:     make(map[string]int),
+			Endpoints: make(map[string]EndpointSummary),
+		},
+		window: defaultProgressWindow,
+	}
+}
+
+// watchBreakers adds the state of the circuit breakers to the report
+func (r *LoadReport) watchBreakers(breakers *Breakers) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakers = breakers
+}
+
+func (r *LoadReport) sessionStarted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.summary.SessionsStarted++
+	r.active++
+}
+
+func (r *LoadReport) recordAllocation(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if err != nil {
+		r.summary.AllocationFailures++
+		return
+	}
+	r.summary.Allocations++
+}
+
+func (r *LoadReport) recordSession(result SessionResult, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if result != SessionAbandoned {
+		r.active -= count
+	}
+	switch result {
+	case SessionCompleted:
+		r.summary.SessionsCompleted += count
+	case SessionInterrupted:
+		r.summary.SessionsInterrupted += count
+	case SessionFailed:
+		r.summary.SessionsFailed += count
+	case SessionAbandoned:
+		r.summary.SessionsAbandoned += count
+	}
+}
+
+// recordAttempt records a single allocation request. It is suitable for Client.OnAttempt.
+func (r *LoadReport) recordAttempt(attempt Attempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	code := status.Code(attempt.Err).String()
+	r.summary.Codes[code]++
+	endpoint := r.summary.Endpoints[attempt.Endpoint]
+	endpoint.Attempts++
+	if attempt.Err != nil {
+		endpoint.Errors++
+	}
+	r.summary.Endpoints[attempt.Endpoint] = endpoint
+	r.summary.QueueWait += attempt.QueueWait
+
+	now := time.Now()
+	r.recent = append(r.recent, attemptSample{
+		at:        now,
+		endpoint:  attempt.Endpoint,
+		code:      code,
+		latency:   attempt.Latency,
+		queueWait: attempt.QueueWait,
+		failed:    attempt.Err != nil,
+	})
+	r.prune(now)
+}
+
+// prune drops samples that have fallen out of the window
+func (r *LoadReport) prune(now time.Time) {
+	cutoff := now.Add(-r.window)
+	i := 0
+	for i < len(r.recent) && r.recent[i].at.Before(cutoff) {
+		i++
+	}
+	r.recent = r.recent[i:]
+}
+
+// Progress returns the current state of the test, with rates and latencies
+// calculated over the last 30 seconds
+func (r *LoadReport) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.prune(now)
+
+	progress := Progress{
+		Elapsed:         now.Sub(r.summary.Start),
+		ActiveSessions:  r.active,
+		SessionsStarted: r.summary.SessionsStarted,
+		Allocations:     r.summary.Allocations,
+		Codes:           make(map[string]int),
+		Endpoints:       make(map[string]EndpointProgress),
+	}
+
+	window := r.window
+	if progress.Elapsed < window {
+		window = progress.Elapsed
+	}
+	progress.Window = window
+	latencies := []time.Duration{}
+	queueWaits := []time.Duration{}
+	endpointLatencies := make(map[string][]time.Duration)
+	succeeded, failed := 0, 0
+	for _, sample := range r.recent {
+		progress.Codes[sample.code]++
+		endpoint := progress.Endpoints[sample.endpoint]
+		endpoint.Attempts++
+		if sample.failed {
+			endpoint.Errors++
+			failed++
+		} else {
+			succeeded++
+		}
+		progress.Endpoints[sample.endpoint] = endpoint
+		latencies = append(latencies, sample.latency)
+		queueWaits = append(queueWaits, sample.queueWait)
+		endpointLatencies[sample.endpoint] = append(endpointLatencies[sample.endpoint], sample.latency)
+	}
+
+	if window > 0 {
+		progress.AllocationsPerSecond = float64(succeeded) / window.Seconds()
+	}
+	if succeeded+failed > 0 {
+		progress.ErrorRate = float64(failed) / float64(succeeded+failed)
+	}
+	latencies = sortedDurations(latencies)
+	progress.P50 = percentile(latencies, 50)
+	progress.P90 = percentile(latencies, 90)
+	progress.P99 = percentile(latencies, 99)
+	queueWaits = sortedDurations(queueWaits)
+	progress.QueueWaitP50 = percentile(queueWaits, 50)
+	progress.QueueWaitP99 = percentile(queueWaits, 99)
+	for name, endpoint := range progress.Endpoints {
+		endpoint.P50 = percentile(sortedDurations(endpointLatencies[name]), 50)
+		progress.Endpoints[name] = endpoint
+	}
+	if r.breakers != nil {
+		// Endpoints with an open circuit may have no recent attempts, but are still shown
+		for name, breaker := range r.breakers.Statuses() {
+			endpoint := progress.Endpoints[name]
+			endpoint.Circuit = breaker.State
+			progress.Endpoints[name] = endpoint
+		}
+	}
+	return progress
+}
+
+func (r *LoadReport) finish(interrupted bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.End = time.Now()
+	r.summary.Interrupted = interrupted
+	r.closed = true
+	if r.breakers != nil {
+		for name, breaker := range r.breakers.Statuses() {
+			if breaker.Opened == 0 {
+				continue
+			}
+			endpoint := r.summary.Endpoints[name]
+			endpoint.CircuitOpened = breaker.Opened
+			r.summary.Endpoints[name] = endpoint
+		}
+	}
+}
+
+// Summary returns a copy of the results as they currently stand
+func (r *LoadReport) Summary() LoadSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.summary
+	summary.Codes = make(map[string]int, len(r.summary.Codes))
+	for code, count := range r.summary.Codes {
+		summary.Codes[code] = count
+	}
+	summary.Endpoints = make(map[string]EndpointSummary, len(r.summary.Endpoints))
+	for endpoint, stats := range r.summary.Endpoints {
+		summary.Endpoints[endpoint] = stats
+	}
+	if summary.End.IsZero() {
+		summary.End = time.Now()
+	}
+	return summary
+}
+
+// Print writes a human readable summary of the report
+func (r *LoadReport) Print(w io.Writer) {
+	r.Summary().Print(w)
+}
+
+// MergeLoadSummaries combines the results of several load tests, such as the
+// ones run by distributed workers, into one
+func MergeLoadSummaries(summaries ...LoadSummary) LoadSummary {
+	merged := LoadSummary{
+		Codes:     make(map[string]int),
+		Endpoints: make(map[string]EndpointSummary),
+	}
+	for _, s := range summaries {
+		if merged.Start.IsZero() || (!s.Start.IsZero() && s.Start.Before(merged.Start)) {
+			merged.Start = s.Start
+		}
+		if s.End.After(merged.End) {
+			merged.End = s.End
+		}
+		merged.Interrupted = merged.Interrupted || s.Interrupted
+		merged.SessionsStarted += s.SessionsStarted
+		merged.Allocations += s.Allocations
+		merged.AllocationFailures += s.AllocationFailures
+		merged.SessionsCompleted += s.SessionsCompleted
+		merged.SessionsInterrupted += s.SessionsInterrupted
+		merged.SessionsFailed += s.SessionsFailed
+		merged.SessionsAbandoned += s.SessionsAbandoned
+		merged.QueueWait += s.QueueWait
+		for code, count := range s.Codes {
+			merged.Codes[code] += count
+		}
+		for name, stats := range s.Endpoints {
+			endpoint := merged.Endpoints[name]
+			endpoint.Attempts += stats.Attempts
+			endpoint.Errors += stats.Errors
+			endpoint.CircuitOpened += stats.CircuitOpened
+			merged.Endpoints[name] = endpoint
+		}
+	}
+	return merged
+}
+
+// Print writes a human readable summary
+func (s LoadSummary) Print(w io.Writer) {
+	status := "finished"
+	if s.Interrupted {
+		status = "interrupted"
+	}
+	fmt.Fprintf(w, "Load test %s after %s\n", status, s.End.Sub(s.Start).Round(time.Millisecond))
+	fmt.Fprintf(w, "  sessions started:     %d\n", s.SessionsStarted)
+	fmt.Fprintf(w, "  allocations:          %d\n", s.Allocations)
+	fmt.Fprintf(w, "  allocation failures:  %d\n", s.AllocationFailures)
+	fmt.Fprintf(w, "  sessions completed:   %d\n", s.SessionsCompleted)
+	fmt.Fprintf(w, "  sessions interrupted: %d\n", s.SessionsInterrupted)
+	fmt.Fprintf(w, "  sessions failed:      %d\n", s.SessionsFailed)
+	fmt.Fprintf(w, "  sessions abandoned:   %d\n", s.SessionsAbandoned)
+	attempts := 0
+	for _, count := range s.Codes {
+		attempts += count
+	}
+	if s.QueueWait > 0 && attempts > 0 {
+		fmt.Fprintf(w, "  client queue wait:    %s in total, %s per attempt\n", s.QueueWait.Round(time.Millisecond), (s.QueueWait / time.Duration(attempts)).Round(time.Microsecond))
+	}
+	if len(s.Codes) > 0 {
+		fmt.Fprintln(w, "  allocation attempts by code:")
+		for _, code := range sortedKeys(s.Codes) {
+			fmt.Fprintf(w, "    %-20s %d\n", code, s.Codes[code])
+		}
+	}
+	if len(s.Endpoints) > 0 {
+		fmt.Fprintln(w, "  allocation attempts by endpoint:")
+		names := make([]string, 0, len(s.Endpoints))
+		for name := range s.Endpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			endpoint := s.Endpoints[name]
+			line := fmt.Sprintf("    %-40s %d attempts, %d errors", name, endpoint.Attempts, endpoint.Errors)
+			if endpoint.CircuitOpened > 0 {
+				line += fmt.Sprintf(", circuit opened %d times", endpoint.CircuitOpened)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a count map in order
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedDurations returns a sorted copy of a list of durations
+func sortedDurations(durations []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// percentile returns the pth percentile of a sorted list of durations using the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
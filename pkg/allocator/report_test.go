@@ -0,0 +1,167 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadReport(t *testing.T) {
+	report := NewLoadReport()
+	report.sessionStarted()
+	report.sessionStarted()
+	report.recordAllocation(nil)
+	report.recordAllocation(fmt.Errorf("no gameservers"))
+	report.recordAttempt(Attempt{Endpoint: "east:443", Latency: 10 * time.Millisecond})
+	report.recordAttempt(Attempt{Endpoint: "east:443", Latency: 30 * time.Millisecond, Err: status.Error(codes.ResourceExhausted, "no gameservers")})
+	report.recordAttempt(Attempt{Endpoint: "west:443", Latency: 20 * time.Millisecond, QueueWait: 5 * time.Millisecond})
+
+	progress := report.Progress()
+	assert.Equal(t, 2, progress.ActiveSessions)
+	assert.Equal(t, map[string]int{"OK": 2, "ResourceExhausted": 1}, progress.Codes)
+	assert.InDelta(t, 1.0/3, progress.ErrorRate, 0.001)
+	assert.Equal(t, 20*time.Millisecond, progress.P50)
+	assert.Equal(t, 30*time.Millisecond, progress.P99)
+	assert.Equal(t, time.Duration(0), progress.QueueWaitP50)
+	assert.Equal(t, 5*time.Millisecond, progress.QueueWaitP99)
+	assert.Equal(t, EndpointProgress{Attempts: 2, Errors: 1, P50: 10 * time.Millisecond}, progress.Endpoints["east:443"])
+
+	report.recordSession(SessionCompleted, 1)
+	report.recordSession(SessionAbandoned, 1)
+	report.finish(true)
+
+	// Abandoned sessions that finish late should not be counted twice
+	report.recordSession(SessionCompleted, 1)
+
+	got := report.Summary()
+	assert.Equal(t, 2, got.SessionsStarted)
+	assert.Equal(t, 1, got.Allocations)
+	assert.Equal(t, 1, got.AllocationFailures)
+	assert.Equal(t, 1, got.SessionsCompleted)
+	assert.Equal(t, 1, got.SessionsAbandoned)
+	assert.Equal(t, EndpointSummary{Attempts: 1}, got.Endpoints["west:443"])
+	assert.Equal(t, 5*time.Millisecond, got.QueueWait)
+	assert.True(t, got.Interrupted)
+	assert.False(t, got.End.IsZero())
+}
+
+func Test_percentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		name   string
+		values []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{
+			name:   "empty",
+			values: nil,
+			p:      50,
+			want:   0,
+		},
+		{
+			name:   "median",
+			values: sorted,
+			p:      50,
+			want:   5,
+		},
+		{
+			name:   "p99",
+			values: sorted,
+			p:      99,
+			want:   10,
+		},
+		{
+			name:   "max",
+			values: sorted,
+			p:      100,
+			want:   10,
+		},
+		{
+			name:   "min",
+			values: sorted,
+			p:      0,
+			want:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, percentile(tt.values, tt.p))
+		})
+	}
+}
+
+func TestMergeLoadSummaries(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	got := MergeLoadSummaries(
+		LoadSummary{
+			Start:             start.Add(time.Second),
+			End:               start.Add(10 * time.Second),
+			SessionsStarted:   2,
+			Allocations:       2,
+			SessionsCompleted: 2,
+			Codes:             map[string]int{"OK": 2},
+			Endpoints:         map[string]EndpointSummary{"east:443": {Attempts: 2}},
+			QueueWait:         time.Second,
+		},
+		LoadSummary{
+			Start:              start,
+			End:                start.Add(5 * time.Second),
+			Interrupted:        true,
+			SessionsStarted:    3,
+			Allocations:        1,
+			AllocationFailures: 2,
+			SessionsFailed:     2,
+			SessionsAbandoned:  1,
+			Codes:              map[string]int{"OK": 1, "ResourceExhausted": 2},
+			Endpoints:          map[string]EndpointSummary{"east:443": {Attempts: 1, Errors: 1}, "west:443": {Attempts: 2, Errors: 1}},
+			QueueWait:          2 * time.Second,
+		},
+	)
+	want := LoadSummary{
+		Start:              start,
+		End:                start.Add(10 * time.Second),
+		Interrupted:        true,
+		SessionsStarted:    5,
+		Allocations:        3,
+		AllocationFailures: 2,
+		SessionsCompleted:  2,
+		SessionsFailed:     2,
+		SessionsAbandoned:  1,
+		Codes:              map[string]int{"OK": 3, "ResourceExhausted": 2},
+		Endpoints:          map[string]EndpointSummary{"east:443": {Attempts: 3, Errors: 1}, "west:443": {Attempts: 2, Errors: 1}},
+		QueueWait:          3 * time.Second,
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestLoadReport_breakers(t *testing.T) {
+	breakers := NewBreakers(BreakerOptions{Failures: 1})
+	report := NewLoadReport()
+	report.watchBreakers(breakers)
+	breakers.record("east:443", 0, status.Error(codes.Unavailable, "down"))
+
+	assert.Equal(t, BreakerOpen, report.Progress().Endpoints["east:443"].Circuit)
+	report.finish(false)
+	assert.Equal(t, 1, report.Summary().Endpoints["east:443"].CircuitOpened)
+}
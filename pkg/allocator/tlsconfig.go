@@ -0,0 +1,119 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"k8s.io/klog"
+)
+
+// TLSOptions are the settings layered on top of the client certificates
+type TLSOptions struct {
+	// ServerName, if set, is the name the allocator certificate is verified against for
+	// every endpoint, instead of the endpoint's host. Use it when reaching the allocator by
+	// IP or through a tunnel.
+	ServerName string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13. Zero uses the Go default.
+	MinVersion uint16
+	// CipherSuites limits the cipher suites offered for TLS 1.2 and below. Empty uses the Go default.
+	CipherSuites []uint16
+	// InsecureSkipVerify turns off verification of the allocator certificate. Only use it
+	// against lab clusters.
+	InsecureSkipVerify bool
+}
+
+// apply sets the options on a TLS configuration
+func (o TLSOptions) apply(config *tls.Config) {
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+	if o.MinVersion != 0 {
+		config.MinVersion = o.MinVersion
+	}
+	if len(o.CipherSuites) > 0 {
+		config.CipherSuites = o.CipherSuites
+	}
+	if o.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = nil
+		config.RootCAs = nil
+	}
+}
+
+// ConfigureTLS applies the options to every request the client makes, whichever endpoint is chosen.
+// Set the Transport after calling this, since transports capture the TLS configuration.
+func (c *Client) ConfigureTLS(opts TLSOptions) {
+	if opts.InsecureSkipVerify {
+		klog.Warning("*** TLS verification of the allocator is DISABLED. Anyone in the path can impersonate it. Never do this outside of a lab cluster. ***")
+	}
+	c.TLSOptions = opts
+	c.setTLSConfig(c.baseTLSConfig)
+}
+
+// setTLSConfig stores the base configuration, and builds TLSConfig and DialOpts from it with the TLS options applied
+func (c *Client) setTLSConfig(base *tls.Config) {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	c.baseTLSConfig = base
+	config := base.Clone()
+	c.TLSOptions.apply(config)
+	c.TLSConfig = config
+	c.DialOpts = grpc.WithTransportCredentials(credentials.NewTLS(config))
+}
+
+// ParseTLSVersion converts a version like "1.2" to its crypto/tls constant
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %s - must be one of 1.0, 1.1, 1.2 or 1.3", version)
+	}
+}
+
+// ParseCipherSuites converts cipher suite names, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, to their IDs
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
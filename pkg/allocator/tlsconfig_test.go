@@ -0,0 +1,86 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "", want: 0},
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "TLS1.3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ParseTLSVersion(tt.version)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, got)
+
+	_, err = ParseCipherSuites([]string{"TLS_MADE_UP"})
+	assert.Error(t, err)
+}
+
+func TestClient_ConfigureTLS(t *testing.T) {
+	roots := x509.NewCertPool()
+	c := &Client{}
+	c.setTLSConfig(&tls.Config{RootCAs: roots})
+
+	c.ConfigureTLS(TLSOptions{
+		ServerName:   "allocator.example.com",
+		MinVersion:   tls.VersionTLS13,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	assert.Equal(t, "allocator.example.com", c.TLSConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.TLSConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, c.TLSConfig.CipherSuites)
+	assert.Equal(t, roots, c.TLSConfig.RootCAs)
+	assert.False(t, c.TLSConfig.InsecureSkipVerify)
+	assert.NotNil(t, c.DialOpts)
+
+	c.ConfigureTLS(TLSOptions{InsecureSkipVerify: true})
+	assert.True(t, c.TLSConfig.InsecureSkipVerify)
+	assert.Nil(t, c.TLSConfig.RootCAs)
+	assert.Empty(t, c.TLSConfig.ServerName)
+
+	// The options are applied to the base config, so they can be turned off again
+	c.ConfigureTLS(TLSOptions{})
+	assert.False(t, c.TLSConfig.InsecureSkipVerify)
+	assert.Equal(t, roots, c.TLSConfig.RootCAs)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/golang/protobuf/jsonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// restPath is where the allocator serves the allocation API as JSON
+const restPath = "/gameserverallocation"
+
+// Transport sends an allocation request to an allocator endpoint
+type Transport interface {
+	// Allocate sends the request to the endpoint. creds are the client's per-request
+	// credentials, or nil if it has none. They must be sent with the request, and a transport
+	// that cannot send them as they require, e.g. over a secure connection, must fail the
+	// request instead of leaving them out.
+	Allocate(ctx context.Context, endpoint string, request *pb.AllocationRequest, creds credentials.PerRPCCredentials) (*pb.AllocationResponse, error)
+}
+
+// TransportFor returns the named transport, either grpc or rest, built from the client's TLS settings
+func (c *Client) TransportFor(name string) (Transport, error) {
+	switch name {
+	case "grpc":
+		return NewGRPCTransport(c.DialOpts), nil
+	case "rest":
+		return NewRESTTransport(c.TLSConfig), nil
+	default:
+		return nil, fmt.Errorf("transport must be one of (grpc|rest)")
+	}
+}
+
+type grpcTransport struct {
+	dialOpts []grpc.DialOption
+}
+
+// NewGRPCTransport returns a transport that uses the allocator's gRPC API
+func NewGRPCTransport(dialOpts ...grpc.DialOption) Transport {
+	return &grpcTransport{
+		dialOpts: dialOpts,
+	}
+}
+
+func (t *grpcTransport) Allocate(ctx context.Context, endpoint string, request *pb.AllocationRequest, creds credentials.PerRPCCredentials) (*pb.AllocationResponse, error) {
+	conn, err := grpc.Dial(endpoint, t.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// gRPC refuses to send credentials that require transport security over a plaintext connection
+	var callOpts []grpc.CallOption
+	if creds != nil {
+		callOpts = append(callOpts, grpc.PerRPCCredentials(creds))
+	}
+	grpcClient := pb.NewAllocationServiceClient(conn)
+	return grpcClient.Allocate(ctx, request, callOpts...)
+}
+
+type restTransport struct {
+	client *http.Client
+}
+
+// NewRESTTransport returns a transport that uses the allocator's JSON API over HTTPS.
+// This works through L7 proxies that do not pass gRPC.
+func NewRESTTransport(tlsConfig *tls.Config) Transport {
+	return &restTransport{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// restError is the error body returned by the allocator's JSON API
+type restError struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+func (t *restTransport) Allocate(ctx context.Context, endpoint string, request *pb.AllocationRequest, creds credentials.PerRPCCredentials) (*pb.AllocationResponse, error) {
+	// The JSON API speaks the protobuf JSON mapping, not the Go struct tags
+	body, err := (&jsonpb.Marshaler{}).MarshalToString(request)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("https://%s%s", strings.TrimSuffix(endpoint, "/"), restPath)
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Requests always go over HTTPS, so credentials that require transport security can be sent
+	if creds != nil {
+		md, err := creds.GetRequestMetadata(ctx, "https://"+endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("could not get request credentials - %s", err.Error())
+		}
+		for k, v := range md {
+			req.Header.Add(k, v)
+		}
+	}
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, restStatus(resp.StatusCode, data)
+	}
+
+	response := &pb.AllocationResponse{}
+	// Newer allocators may send fields this client does not know yet
+	err = (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(data), response)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode allocator response - %s", err.Error())
+	}
+	return response, nil
+}
+
+// restStatus turns an error from the JSON API into the same gRPC status the gRPC API would
+// have returned, so that both transports report errors the same way
+func restStatus(httpStatus int, body []byte) error {
+	restErr := restError{}
+	if json.Unmarshal(body, &restErr) == nil && restErr.Code != 0 {
+		message := restErr.Message
+		if message == "" {
+			message = restErr.Error
+		}
+		return status.Error(codes.Code(restErr.Code), message)
+	}
+	return status.Error(httpStatusToCode(httpStatus), strings.TrimSpace(string(body)))
+}
+
+func httpStatusToCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
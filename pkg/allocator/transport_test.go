@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRESTTransport_Allocate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != restPath || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		// Like the allocator's gateway, only accept the protobuf JSON mapping
+		request := &pb.AllocationRequest{}
+		if err := jsonpb.Unmarshal(r.Body, request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch request.Namespace {
+		case "default":
+			_, _ = w.Write([]byte(`{"gameServerName":"gs-1","ports":[{"name":"default","port":7654}],"address":"10.0.0.1","nodeName":"node-1","source":"local"}`))
+		case "empty":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"no gameservers","code":8,"message":"no gameservers"}`))
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	transport := NewRESTTransport(server.Client().Transport.(*http.Transport).TLSClientConfig)
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		name      string
+		namespace string
+		want      *Allocation
+		wantCode  codes.Code
+	}{
+		{
+			name:      "allocated",
+			namespace: "default",
+			want:      &Allocation{GameServerName: "gs-1", NodeName: "node-1", Address: "10.0.0.1", Port: 7654, Ports: map[string]int32{"default": 7654}},
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "status in body",
+			namespace: "empty",
+			wantCode:  codes.ResourceExhausted,
+		},
+		{
+			name:      "status from http code",
+			namespace: "other",
+			wantCode:  codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := transport.Allocate(context.Background(), endpoint, &pb.AllocationRequest{Namespace: tt.namespace}, nil)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.want != nil {
+				got, err := newAllocation(resp)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocatortest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Certificates are a throwaway CA with a server and a client certificate signed by it.
+// All of the PEM fields are in the format the allocator client expects.
+type Certificates struct {
+	// CA is the PEM encoded CA certificate that signed both the server and the client
+	CA []byte
+	// ClientCert is the PEM encoded client certificate
+	ClientCert []byte
+	// ClientKey is the PEM encoded key for ClientCert
+	ClientKey []byte
+	// ServerCert is the PEM encoded server certificate, valid for localhost, 127.0.0.1 and ::1
+	ServerCert []byte
+	// ServerKey is the PEM encoded key for ServerCert
+	ServerKey []byte
+}
+
+// NewCertificates generates a new CA, server certificate and client certificate. They are
+// valid for a day. Extra host names or IP addresses for the server certificate can be passed.
+func NewCertificates(hosts ...string) (*Certificates, error) {
+	caKey, caDER, err := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "allocatortest-ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "allocatortest-server"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	serverKey, serverDER, err := newCertificate(server, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey, clientDER, err := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "allocatortest-client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := &Certificates{CA: encodeCertificate(caDER)}
+	certs.ServerCert = encodeCertificate(serverDER)
+	if certs.ServerKey, err = encodeKey(serverKey); err != nil {
+		return nil, err
+	}
+	certs.ClientCert = encodeCertificate(clientDER)
+	if certs.ClientKey, err = encodeKey(clientKey); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// WriteClientFiles writes the client key, client certificate and CA to files in dir, so that they
+// can be passed to allocator.NewClient or the command line
+func (c *Certificates) WriteClientFiles(dir string) (keyFile, certFile, caFile string, err error) {
+	keyFile = filepath.Join(dir, "client.key")
+	certFile = filepath.Join(dir, "client.crt")
+	caFile = filepath.Join(dir, "ca.crt")
+	if err = ioutil.WriteFile(keyFile, c.ClientKey, 0600); err != nil {
+		return "", "", "", err
+	}
+	if err = ioutil.WriteFile(certFile, c.ClientCert, 0600); err != nil {
+		return "", "", "", err
+	}
+	if err = ioutil.WriteFile(caFile, c.CA, 0600); err != nil {
+		return "", "", "", err
+	}
+	return keyFile, certFile, caFile, nil
+}
+
+// serverTLSConfig returns a config that serves the server certificate and requires a client
+// certificate signed by the CA
+func (c *Certificates) serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(c.ServerCert, c.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.CA)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// newCertificate creates a certificate from the template, signed by the parent, or self-signed
+// if there is no parent
+func newCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, der, nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocatortest
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exitMessage ends a session, as it does on the Agones simple game server
+const exitMessage = "EXIT"
+
+// Faults are the problems a SimpleGameServer can simulate
+type Faults struct {
+	// DropRate is the chance, from 0 to 1, that a message gets no reply. Dropped messages are
+	// still recorded.
+	DropRate float64
+	// ReplyDelay is how long to wait before each reply
+	ReplyDelay time.Duration
+}
+
+// Session is the traffic from one player
+type Session struct {
+	// Client is the player's address
+	Client string
+	// Messages are the messages received, in order
+	Messages []string
+	// Replies is the number of messages that were acknowledged
+	Replies int
+	// Exited is true once the player sent EXIT
+	Exited bool
+	Start  time.Time
+}
+
+// SimpleGameServer is a stand-in for the Agones simple-game-server example, over either UDP
+// or TCP. It acknowledges every message with "ACK: <message>", and a session ends when the
+// player sends EXIT. Unlike the real one, EXIT does not shut the whole server down, so many
+// sessions can share it.
+type SimpleGameServer struct {
+	// Protocol is udp or tcp
+	Protocol string
+	// Address is the host:port the server is listening on
+	Address string
+
+	listener   net.Listener
+	packetConn net.PacketConn
+	wg         sync.WaitGroup
+
+	mu       sync.Mutex
+	faults   Faults
+	random   *rand.Rand
+	sessions map[string]*Session
+	order    []string
+	conns    map[net.Conn]struct{}
+	crashed  bool
+}
+
+// NewSimpleGameServer starts a simple game server on a random port on 127.0.0.1
+func NewSimpleGameServer(protocol string) (*SimpleGameServer, error) {
+	g := &SimpleGameServer{
+		Protocol: protocol,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		sessions: map[string]*Session{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	switch protocol {
+	case "tcp":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		g.listener = listener
+		g.Address = listener.Addr().String()
+		g.wg.Add(1)
+		go g.serveTCP()
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		g.packetConn = conn
+		g.Address = conn.LocalAddr().String()
+		g.wg.Add(1)
+		go g.serveUDP()
+	default:
+		return nil, fmt.Errorf("proto must be one of (udp|tcp)")
+	}
+	return g, nil
+}
+
+// GameServer returns a fleet entry for a fake allocator that points at this server, so that
+// allocations lead players here
+func (g *SimpleGameServer) GameServer(name string) GameServer {
+	host, port, _ := net.SplitHostPort(g.Address)
+	portNumber, _ := strconv.Atoi(port)
+	return GameServer{
+		Name:    name,
+		Address: host,
+		Ports:   []Port{{Name: "default", Port: int32(portNumber)}},
+	}
+}
+
+// SetFaults changes the faults for every message from now on
+func (g *SimpleGameServer) SetFaults(faults Faults) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.faults = faults
+}
+
+// Sessions returns a copy of every session so far, in the order they started
+func (g *SimpleGameServer) Sessions() []Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sessions := make([]Session, 0, len(g.order))
+	for _, client := range g.order {
+		session := *g.sessions[client]
+		session.Messages = append([]string{}, session.Messages...)
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Crash stops the server abruptly, as if the process died. Open TCP connections are closed
+// without a reply, new ones are refused, and UDP messages are lost.
+func (g *SimpleGameServer) Crash() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.crashed {
+		return
+	}
+	g.crashed = true
+	if g.listener != nil {
+		g.listener.Close()
+	}
+	if g.packetConn != nil {
+		g.packetConn.Close()
+	}
+	for conn := range g.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and waits for it to finish
+func (g *SimpleGameServer) Close() {
+	g.Crash()
+	g.wg.Wait()
+}
+
+// receive records a message and returns the reply and how long to wait before sending it,
+// or false if the reply is dropped
+func (g *SimpleGameServer) receive(client string, message string) (string, time.Duration, bool) {
+	g.mu.Lock()
+	session, ok := g.sessions[client]
+	if !ok {
+		session = &Session{Client: client, Start: time.Now()}
+		g.sessions[client] = session
+		g.order = append(g.order, client)
+	}
+	session.Messages = append(session.Messages, message)
+	if message == exitMessage {
+		session.Exited = true
+	}
+	faults := g.faults
+	dropped := faults.DropRate > 0 && g.random.Float64() < faults.DropRate
+	if !dropped {
+		session.Replies++
+	}
+	g.mu.Unlock()
+
+	if dropped {
+		return "", 0, false
+	}
+	return fmt.Sprintf("ACK: %s\n", message), faults.ReplyDelay, true
+}
+
+func (g *SimpleGameServer) serveTCP() {
+	defer g.wg.Done()
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		g.mu.Lock()
+		if g.crashed {
+			g.mu.Unlock()
+			conn.Close()
+			return
+		}
+		g.conns[conn] = struct{}{}
+		g.mu.Unlock()
+
+		g.wg.Add(1)
+		go g.handleTCP(conn)
+	}
+}
+
+func (g *SimpleGameServer) handleTCP(conn net.Conn) {
+	defer g.wg.Done()
+	defer func() {
+		g.mu.Lock()
+		delete(g.conns, conn)
+		g.mu.Unlock()
+		conn.Close()
+	}()
+
+	client := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		message := strings.TrimSpace(scanner.Text())
+		reply, delay, ok := g.receive(client, message)
+		if ok {
+			time.Sleep(delay)
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+		if message == exitMessage {
+			return
+		}
+	}
+}
+
+func (g *SimpleGameServer) serveUDP() {
+	defer g.wg.Done()
+	buffer := make([]byte, 1024)
+	for {
+		n, addr, err := g.packetConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		message := strings.TrimSpace(string(buffer[:n]))
+		reply, delay, ok := g.receive(addr.String(), message)
+		if !ok {
+			continue
+		}
+		if delay == 0 {
+			_, _ = g.packetConn.WriteTo([]byte(reply), addr)
+			continue
+		}
+		// Delay replies without holding up the other players
+		time.AfterFunc(delay, func() {
+			_, _ = g.packetConn.WriteTo([]byte(reply), addr)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocatortest
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exchange sends a message and returns the reply, or an error if none arrives in time
+func exchange(t *testing.T, conn net.Conn, message string, timeout time.Duration) (string, error) {
+	_, err := conn.Write([]byte(message + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestSimpleGameServer(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		t.Run(protocol, func(t *testing.T) {
+			g, err := NewSimpleGameServer(protocol)
+			require.NoError(t, err)
+			defer g.Close()
+
+			entry := g.GameServer("gs-1")
+			assert.Equal(t, "127.0.0.1", entry.Address)
+			assert.NotZero(t, entry.Ports[0].Port)
+
+			conn, err := net.Dial(protocol, g.Address)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			reply, err := exchange(t, conn, "HELLO", time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, "ACK: HELLO\n", reply)
+
+			g.SetFaults(Faults{DropRate: 1})
+			_, err = exchange(t, conn, "dropped", 50*time.Millisecond)
+			assert.Error(t, err)
+
+			g.SetFaults(Faults{ReplyDelay: 100 * time.Millisecond})
+			_, err = exchange(t, conn, "slow", 20*time.Millisecond)
+			assert.Error(t, err, "the reply should not arrive before the delay")
+			// Wait for the late reply, so that it is not taken for the reply to EXIT
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			reply, err = bufio.NewReader(conn).ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "ACK: slow\n", reply)
+
+			g.SetFaults(Faults{})
+			_, err = exchange(t, conn, "EXIT", time.Second)
+			require.NoError(t, err)
+
+			sessions := g.Sessions()
+			require.Len(t, sessions, 1)
+			assert.Equal(t, []string{"HELLO", "dropped", "slow", "EXIT"}, sessions[0].Messages)
+			assert.Equal(t, 3, sessions[0].Replies)
+			assert.True(t, sessions[0].Exited)
+		})
+	}
+}
+
+func TestSimpleGameServer_Crash(t *testing.T) {
+	g, err := NewSimpleGameServer("tcp")
+	require.NoError(t, err)
+	defer g.Close()
+
+	conn, err := net.Dial("tcp", g.Address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = exchange(t, conn, "HELLO", time.Second)
+	require.NoError(t, err)
+
+	g.Crash()
+	_, err = exchange(t, conn, "still there?", time.Second)
+	assert.Error(t, err, "open connections are cut")
+	_, err = net.Dial("tcp", g.Address)
+	assert.Error(t, err, "new connections are refused")
+}
@@ -0,0 +1,422 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+// Package allocatortest runs an in-process Agones allocation service for tests. The server
+// speaks the real gRPC API over mTLS with throwaway certificates, answers from a fake fleet
+// or from scripted behaviors, and records every request it receives.
+package allocatortest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GameServerState is the state of a gameserver in the fake fleet
+type GameServerState string
+
+const (
+	// GameServerStateReady gameservers can be allocated
+	GameServerStateReady GameServerState = "Ready"
+	// GameServerStateAllocated gameservers have been handed out and are not allocated again
+	GameServerStateAllocated GameServerState = "Allocated"
+)
+
+// Port is a named gameserver port
+type Port struct {
+	Name string `json:"name"`
+	Port int32  `json:"port"`
+}
+
+// GameServer is a gameserver in the fake fleet
+type GameServer struct {
+	Name string `json:"name"`
+	// Namespace is matched against the request's namespace. Empty matches any namespace.
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	NodeName    string            `json:"nodeName,omitempty"`
+	Address     string            `json:"address"`
+	Ports       []Port            `json:"ports"`
+	// State is Ready if it is left empty
+	State GameServerState `json:"state,omitempty"`
+}
+
+// Request is an allocation request the server received
+type Request struct {
+	Request *pb.AllocationRequest
+	// Metadata is the gRPC metadata sent with the request, e.g. authorization headers
+	Metadata metadata.MD
+	// ClientName is the common name of the client certificate, if there was one
+	ClientName string
+	Time       time.Time
+}
+
+// Behavior decides how the server answers one allocation request
+type Behavior func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error)
+
+// Options configure a Server
+type Options struct {
+	// Address is where to listen. Defaults to a random port on 127.0.0.1.
+	Address string
+	// Certificates to serve with. New ones are generated if this is nil. Pass the same
+	// certificates to several servers to test failover with a single client.
+	Certificates *Certificates
+	// Insecure serves plaintext gRPC instead of mTLS
+	Insecure bool
+}
+
+// Server is a fake allocation service. By default it allocates from its fleet. Scripted
+// behaviors take precedence, one per request, in order.
+type Server struct {
+	// Address is the host:port the server is listening on
+	Address string
+	// Certificates are the credentials a client needs to connect
+	Certificates *Certificates
+
+	grpcServer *grpc.Server
+	listener   net.Listener
+
+	mu         sync.Mutex
+	script     []Behavior
+	fallback   Behavior
+	latency    time.Duration
+	readyAfter time.Duration
+	fleet      []*GameServer
+	// original is the fleet as it was added, to reset gameservers when they are released
+	original []GameServer
+	requests []Request
+}
+
+// NewServer starts a fake allocation service. Call Close when done with it.
+func NewServer(opts Options) (*Server, error) {
+	s := &Server{Certificates: opts.Certificates}
+	if s.Certificates == nil {
+		var err error
+		s.Certificates, err = NewCertificates()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var serverOpts []grpc.ServerOption
+	if !opts.Insecure {
+		tlsConfig, err := s.Certificates.serverTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	address := opts.Address
+	if address == "" {
+		address = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s.listener = listener
+	s.Address = listener.Addr().String()
+	s.grpcServer = grpc.NewServer(serverOpts...)
+	pb.RegisterAllocationServiceServer(s.grpcServer, s)
+	go func() {
+		_ = s.grpcServer.Serve(listener)
+	}()
+	return s, nil
+}
+
+// Close stops the server and closes any open connections
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+}
+
+// Script queues behaviors to answer the next requests, one each. Once they are used up the
+// server goes back to its default behavior.
+func (s *Server) Script(behaviors ...Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, behaviors...)
+}
+
+// SetDefault sets how requests are answered when there is nothing scripted. Passing nil goes
+// back to allocating from the fleet.
+func (s *Server) SetDefault(behavior Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = behavior
+}
+
+// SetLatency delays every response, on top of any delay in the behavior
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// SetReadyAfter makes allocated gameservers go back to Ready after a while, as if the game
+// ended and the fleet replaced them. Zero, the default, leaves them allocated.
+func (s *Server) SetReadyAfter(readyAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readyAfter = readyAfter
+}
+
+// AddGameServers adds gameservers to the fleet
+func (s *Server) AddGameServers(gameServers ...GameServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range gameServers {
+		gs := gameServers[i]
+		if gs.State == "" {
+			gs.State = GameServerStateReady
+		}
+		s.fleet = append(s.fleet, &gs)
+		original := gs
+		original.State = GameServerStateReady
+		s.original = append(s.original, original)
+	}
+}
+
+// Release puts the named gameservers back to Ready, with the labels and annotations they were
+// added with
+func (s *Server) Release(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		for i, gs := range s.fleet {
+			if gs.Name == name {
+				*gs = s.original[i]
+			}
+		}
+	}
+}
+
+// GameServers returns a copy of the fleet, in the order the gameservers were added
+func (s *Server) GameServers() []GameServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gameServers := make([]GameServer, 0, len(s.fleet))
+	for _, gs := range s.fleet {
+		gameServers = append(gameServers, *gs)
+	}
+	return gameServers
+}
+
+// Requests returns the requests received so far, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Allocate implements the allocation service
+func (s *Server) Allocate(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	received := Request{Request: request, Time: time.Now()}
+	received.Metadata, _ = metadata.FromIncomingContext(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			received.ClientName = info.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, received)
+	behavior := s.fallback
+	if len(s.script) > 0 {
+		behavior = s.script[0]
+		s.script = s.script[1:]
+	}
+	if behavior == nil {
+		behavior = s.AllocateFromFleet
+	}
+	latency := s.latency
+	s.mu.Unlock()
+
+	if err := sleep(ctx, latency); err != nil {
+		return nil, err
+	}
+	return behavior(ctx, request)
+}
+
+// AllocateFromFleet is the default behavior. It allocates a Ready gameserver that matches the
+// required selector, preferring the preferred selectors in order, and applies the request's
+// MetaPatch to it. Among equally preferred gameservers, Packed scheduling picks the node with
+// the most allocated gameservers and Distributed the node with the fewest, then fleet order
+// breaks ties. When nothing matches it fails with ResourceExhausted.
+func (s *Server) AllocateFromFleet(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := []*GameServer{}
+	for _, gs := range s.fleet {
+		if gs.State != GameServerStateReady {
+			continue
+		}
+		if gs.Namespace != "" && gs.Namespace != request.GetNamespace() {
+			continue
+		}
+		if !matches(request.GetRequiredGameServerSelector(), gs.Labels) {
+			continue
+		}
+		candidates = append(candidates, gs)
+	}
+	if len(candidates) == 0 {
+		return nil, status.Error(codes.ResourceExhausted, "there is no available GameServer to allocate")
+	}
+
+	for _, selector := range request.GetPreferredGameServerSelectors() {
+		if preferred := filter(candidates, selector); len(preferred) > 0 {
+			candidates = preferred
+			break
+		}
+	}
+	chosen := s.schedule(candidates, request.GetScheduling())
+
+	chosen.State = GameServerStateAllocated
+	if patch := request.GetMetaPatch(); patch != nil {
+		chosen.Labels = merge(chosen.Labels, patch.GetLabels())
+		chosen.Annotations = merge(chosen.Annotations, patch.GetAnnotations())
+	}
+	if s.readyAfter > 0 {
+		name := chosen.Name
+		time.AfterFunc(s.readyAfter, func() { s.Release(name) })
+	}
+	return chosen.response(), nil
+}
+
+// schedule picks a candidate by how many gameservers are already allocated on its node
+func (s *Server) schedule(candidates []*GameServer, strategy pb.AllocationRequest_SchedulingStrategy) *GameServer {
+	allocated := map[string]int{}
+	for _, gs := range s.fleet {
+		if gs.State == GameServerStateAllocated {
+			allocated[gs.NodeName]++
+		}
+	}
+	chosen := candidates[0]
+	for _, gs := range candidates[1:] {
+		count, best := allocated[gs.NodeName], allocated[chosen.NodeName]
+		if strategy == pb.AllocationRequest_Distributed && count < best {
+			chosen = gs
+		}
+		if strategy == pb.AllocationRequest_Packed && count > best {
+			chosen = gs
+		}
+	}
+	return chosen
+}
+
+// response is the allocation response for the gameserver
+func (gs *GameServer) response() *pb.AllocationResponse {
+	response := &pb.AllocationResponse{
+		GameServerName: gs.Name,
+		Address:        gs.Address,
+		NodeName:       gs.NodeName,
+	}
+	for _, port := range gs.Ports {
+		response.Ports = append(response.Ports, &pb.AllocationResponse_GameServerStatusPort{Name: port.Name, Port: port.Port})
+	}
+	return response
+}
+
+// Respond always answers with the same response
+func Respond(response *pb.AllocationResponse) Behavior {
+	return func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		return response, nil
+	}
+}
+
+// Fail always fails with the gRPC code and message
+func Fail(code codes.Code, message string) Behavior {
+	return func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		return nil, status.Error(code, message)
+	}
+}
+
+// Delay waits before running the behavior. If the request is cancelled first, the behavior is not run.
+func Delay(delay time.Duration, behavior Behavior) Behavior {
+	return func(ctx context.Context, request *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		return behavior(ctx, request)
+	}
+}
+
+// Repeat returns n copies of the behavior, for Script
+func Repeat(n int, behavior Behavior) []Behavior {
+	behaviors := make([]Behavior, n)
+	for i := range behaviors {
+		behaviors[i] = behavior
+	}
+	return behaviors
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// matches reports whether the labels satisfy the selector. A missing selector matches everything.
+func matches(selector *pb.LabelSelector, labels map[string]string) bool {
+	for k, v := range selector.GetMatchLabels() {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func filter(gameServers []*GameServer, selector *pb.LabelSelector) []*GameServer {
+	matched := []*GameServer{}
+	for _, gs := range gameServers {
+		if matches(selector, gs.Labels) {
+			matched = append(matched, gs)
+		}
+	}
+	return matched
+}
+
+// merge returns a copy of the base with the patch applied
+func merge(base map[string]string, patch map[string]string) map[string]string {
+	if len(patch) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(patch))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		merged[k] = v
+	}
+	return merged
+}
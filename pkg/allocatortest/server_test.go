@@ -0,0 +1,203 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package allocatortest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	pb "agones.dev/agones/pkg/allocation/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(Options{})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func dial(t *testing.T, s *Server, withClientCert bool) pb.AllocationServiceClient {
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(s.Certificates.CA))
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if withClientCert {
+		cert, err := tls.X509KeyPair(s.Certificates.ClientCert, s.Certificates.ClientKey)
+		require.NoError(t, err)
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := grpc.Dial(s.Address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewAllocationServiceClient(conn)
+}
+
+func TestServer_AllocateFromFleet(t *testing.T) {
+	s := newTestServer(t)
+	s.AddGameServers(
+		GameServer{Name: "gs-blue", Labels: map[string]string{"color": "blue"}, Address: "10.0.0.1", Ports: []Port{{Name: "default", Port: 7000}}},
+		GameServer{Name: "gs-red", Labels: map[string]string{"color": "red"}, Address: "10.0.0.2", Ports: []Port{{Name: "default", Port: 7001}}},
+		GameServer{Name: "gs-other", Namespace: "other", Address: "10.0.0.3"},
+	)
+	client := dial(t, s, true)
+	ctx := context.Background()
+
+	resp, err := client.Allocate(ctx, &pb.AllocationRequest{
+		Namespace:                    "default",
+		PreferredGameServerSelectors: []*pb.LabelSelector{{MatchLabels: map[string]string{"color": "red"}}},
+		MetaPatch:                    &pb.MetaPatch{Labels: map[string]string{"run": "one"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "gs-red", resp.GameServerName)
+	assert.Equal(t, "10.0.0.2", resp.Address)
+	assert.Equal(t, int32(7001), resp.Ports[0].Port)
+
+	_, err = client.Allocate(ctx, &pb.AllocationRequest{
+		Namespace:                  "default",
+		RequiredGameServerSelector: &pb.LabelSelector{MatchLabels: map[string]string{"color": "red"}},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "the red gameserver is already allocated")
+
+	resp, err = client.Allocate(ctx, &pb.AllocationRequest{Namespace: "default"})
+	require.NoError(t, err)
+	assert.Equal(t, "gs-blue", resp.GameServerName, "gameservers in other namespaces are skipped")
+
+	fleet := s.GameServers()
+	assert.Equal(t, GameServerStateAllocated, fleet[0].State)
+	assert.Equal(t, GameServerStateAllocated, fleet[1].State)
+	assert.Equal(t, map[string]string{"color": "red", "run": "one"}, fleet[1].Labels)
+	assert.Equal(t, GameServerStateReady, fleet[2].State)
+
+	requests := s.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "allocatortest-client", requests[0].ClientName)
+	assert.Equal(t, "one", requests[0].Request.MetaPatch.Labels["run"])
+}
+
+func TestServer_Script(t *testing.T) {
+	s := newTestServer(t)
+	s.Script(Repeat(2, Fail(codes.Unavailable, "try again"))...)
+	s.Script(Respond(&pb.AllocationResponse{GameServerName: "scripted"}))
+	s.SetDefault(Fail(codes.Internal, "out of script"))
+	client := dial(t, s, true)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer abc")
+	wantCodes := []codes.Code{codes.Unavailable, codes.Unavailable, codes.OK, codes.Internal}
+	for _, want := range wantCodes {
+		resp, err := client.Allocate(ctx, &pb.AllocationRequest{})
+		assert.Equal(t, want, status.Code(err))
+		if want == codes.OK {
+			assert.Equal(t, "scripted", resp.GameServerName)
+		}
+	}
+	requests := s.Requests()
+	require.Len(t, requests, 4)
+	assert.Equal(t, []string{"Bearer abc"}, requests[0].Metadata.Get("authorization"))
+}
+
+func TestServer_Latency(t *testing.T) {
+	s := newTestServer(t)
+	s.SetLatency(50 * time.Millisecond)
+	s.Script(Delay(50*time.Millisecond, Respond(&pb.AllocationResponse{GameServerName: "slow"})))
+	client := dial(t, s, true)
+
+	start := time.Now()
+	resp, err := client.Allocate(context.Background(), &pb.AllocationRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "slow", resp.GameServerName)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Allocate(ctx, &pb.AllocationRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestServer_requiresClientCert(t *testing.T) {
+	s := newTestServer(t)
+	client := dial(t, s, false)
+	_, err := client.Allocate(context.Background(), &pb.AllocationRequest{})
+	assert.Error(t, err)
+	assert.Empty(t, s.Requests())
+}
+
+func TestCertificates_WriteClientFiles(t *testing.T) {
+	certs, err := NewCertificates("allocator.example.com", "10.1.2.3")
+	require.NoError(t, err)
+	keyFile, certFile, caFile, err := certs.WriteClientFiles(t.TempDir())
+	require.NoError(t, err)
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.FileExists(t, caFile)
+
+	serverCert, err := tls.X509KeyPair(certs.ServerCert, certs.ServerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, leaf.VerifyHostname("allocator.example.com"))
+	assert.NoError(t, leaf.VerifyHostname("10.1.2.3"))
+	assert.NoError(t, leaf.VerifyHostname("127.0.0.1"))
+}
+
+func TestServer_Scheduling(t *testing.T) {
+	s := newTestServer(t)
+	s.AddGameServers(
+		GameServer{Name: "a-1", NodeName: "node-a"},
+		GameServer{Name: "b-1", NodeName: "node-b"},
+		GameServer{Name: "a-2", NodeName: "node-a"},
+		GameServer{Name: "b-2", NodeName: "node-b"},
+	)
+	client := dial(t, s, true)
+	allocate := func(scheduling pb.AllocationRequest_SchedulingStrategy) string {
+		resp, err := client.Allocate(context.Background(), &pb.AllocationRequest{Scheduling: scheduling})
+		require.NoError(t, err)
+		return resp.GameServerName
+	}
+
+	assert.Equal(t, "a-1", allocate(pb.AllocationRequest_Packed))
+	assert.Equal(t, "a-2", allocate(pb.AllocationRequest_Packed), "packed fills up node-a first")
+	s.Release("a-2")
+	assert.Equal(t, "b-1", allocate(pb.AllocationRequest_Distributed), "distributed picks the emptier node")
+	assert.Equal(t, "a-2", allocate(pb.AllocationRequest_Distributed))
+}
+
+func TestServer_SetReadyAfter(t *testing.T) {
+	s := newTestServer(t)
+	s.AddGameServers(GameServer{Name: "gs-1", Labels: map[string]string{"mode": "ranked"}})
+	s.SetReadyAfter(50 * time.Millisecond)
+	client := dial(t, s, true)
+
+	request := &pb.AllocationRequest{MetaPatch: &pb.MetaPatch{Labels: map[string]string{"player": "one"}}}
+	_, err := client.Allocate(context.Background(), request)
+	require.NoError(t, err)
+	_, err = client.Allocate(context.Background(), request)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	require.Eventually(t, func() bool {
+		return s.GameServers()[0].State == GameServerStateReady
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"mode": "ranked"}, s.GameServers()[0].Labels, "the MetaPatch is gone once it is released")
+}
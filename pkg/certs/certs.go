@@ -0,0 +1,297 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// expiryWarning is how close to expiry a certificate has to be before it is called out
+const expiryWarning = 14 * 24 * time.Hour
+
+// Info is the part of a certificate that matters when debugging mTLS
+type Info struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	IsCA        bool      `json:"isCA"`
+}
+
+// Describe summarizes a certificate
+func Describe(cert *x509.Certificate) Info {
+	info := Info{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		IsCA:      cert.IsCA,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+// Print writes the certificate details, one per line, with the given indent
+func (i Info) Print(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%ssubject: %s\n", indent, i.Subject)
+	fmt.Fprintf(w, "%sissuer:  %s\n", indent, i.Issuer)
+	if len(i.DNSNames) > 0 || len(i.IPAddresses) > 0 {
+		fmt.Fprintf(w, "%sSANs:    %s\n", indent, strings.Join(append(append([]string{}, i.DNSNames...), i.IPAddresses...), ", "))
+	}
+	fmt.Fprintf(w, "%svalid:   %s to %s (%s)\n", indent, i.NotBefore.Format(time.RFC3339), i.NotAfter.Format(time.RFC3339), i.validity(time.Now()))
+}
+
+func (i Info) validity(now time.Time) string {
+	switch {
+	case now.Before(i.NotBefore):
+		return "not yet valid"
+	case now.After(i.NotAfter):
+		return "EXPIRED"
+	default:
+		return fmt.Sprintf("expires in %s", i.NotAfter.Sub(now).Round(time.Hour))
+	}
+}
+
+// problems returns anything wrong with the validity period of the certificate
+func (i Info) problems(name string, now time.Time) (problems []string, warnings []string) {
+	switch {
+	case now.Before(i.NotBefore):
+		problems = append(problems, fmt.Sprintf("%s is not valid until %s", name, i.NotBefore.Format(time.RFC3339)))
+	case now.After(i.NotAfter):
+		problems = append(problems, fmt.Sprintf("%s expired at %s", name, i.NotAfter.Format(time.RFC3339)))
+	case i.NotAfter.Sub(now) < expiryWarning:
+		warnings = append(warnings, fmt.Sprintf("%s expires soon, at %s", name, i.NotAfter.Format(time.RFC3339)))
+	}
+	return problems, warnings
+}
+
+// Report is the result of checking a set of client credentials
+type Report struct {
+	Client   Info
+	CA       []Info
+	Hosts    []HostResult
+	Problems []string
+	Warnings []string
+}
+
+// OK is true if nothing is wrong with the credentials or the hosts
+func (r *Report) OK() bool {
+	if len(r.Problems) > 0 {
+		return false
+	}
+	for _, host := range r.Hosts {
+		if !host.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCertificates decodes every certificate in a PEM bundle
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// Check loads the client key, client cert and CA, and checks that they are usable together.
+// An error is only returned if they can't be parsed at all. Everything else is in the report.
+func Check(key, cert, ca []byte) (*Report, error) {
+	now := time.Now()
+	report := &Report{}
+
+	clientCerts, err := ParseCertificates(cert)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse client cert: %s", err.Error())
+	}
+	report.Client = Describe(clientCerts[0])
+	problems, warnings := report.Client.problems("client cert", now)
+	report.Problems = append(report.Problems, problems...)
+	report.Warnings = append(report.Warnings, warnings...)
+
+	_, err = tls.X509KeyPair(cert, key)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("client key and cert do not match: %s", err.Error()))
+	}
+
+	if len(ca) == 0 {
+		report.Warnings = append(report.Warnings, "no CA cert given - the system roots will be used to verify the allocator")
+		return report, nil
+	}
+	caCerts, err := ParseCertificates(ca)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA cert: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		info := Describe(caCert)
+		report.CA = append(report.CA, info)
+		problems, warnings := info.problems(fmt.Sprintf("CA cert %q", info.Subject), now)
+		report.Problems = append(report.Problems, problems...)
+		report.Warnings = append(report.Warnings, warnings...)
+		if !caCert.IsCA {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("CA cert %q is not marked as a CA", info.Subject))
+		}
+		roots.AddCert(caCert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range clientCerts[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = clientCerts[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		// The Agones allocator trusts client certs through its own client CA secret, so
+		// this is only a problem if the same CA is used for both
+		report.Warnings = append(report.Warnings, fmt.Sprintf("client cert does not chain to the CA cert: %s", err.Error()))
+	}
+	return report, nil
+}
+
+// HostResult is the outcome of a TLS handshake against an allocator host
+type HostResult struct {
+	Host        string
+	Server      *Info
+	Err         error
+	ChainErr    error
+	HostnameErr error
+}
+
+// OK is true if the handshake succeeded and the server cert is trusted for the host
+func (h HostResult) OK() bool {
+	return h.Err == nil && h.ChainErr == nil && h.HostnameErr == nil
+}
+
+// CheckHost does a TLS handshake against host using the client cert. The server cert is
+// checked against roots and the host name separately, so that each problem is reported on
+// its own. A nil roots uses the system roots. If serverName is set, it is checked instead of the host name.
+func CheckHost(ctx context.Context, host string, serverName string, clientCert tls.Certificate, roots *x509.CertPool) HostResult {
+	result := HostResult{Host: host}
+	address := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		address = net.JoinHostPort(host, "443")
+	}
+	hostname, _, _ := net.SplitHostPort(address)
+	if serverName != "" {
+		hostname = serverName
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			ServerName:   hostname,
+			// Verification is done below, so that the server cert can be reported either way
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+
+	peers := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		result.Err = fmt.Errorf("server did not present a certificate")
+		return result
+	}
+	info := Describe(peers[0])
+	result.Server = &info
+
+	intermediates := x509.NewCertPool()
+	for _, c := range peers[1:] {
+		intermediates.AddCert(c)
+	}
+	_, result.ChainErr = peers[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	result.HostnameErr = peers[0].VerifyHostname(hostname)
+	return result
+}
+
+// Print writes the report in a human readable form
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintln(w, "Client cert:")
+	r.Client.Print(w, "  ")
+	for _, ca := range r.CA {
+		fmt.Fprintln(w, "CA cert:")
+		ca.Print(w, "  ")
+	}
+	for _, host := range r.Hosts {
+		fmt.Fprintf(w, "Host %s:\n", host.Host)
+		if host.Err != nil {
+			fmt.Fprintf(w, "  handshake failed: %s\n", host.Err.Error())
+			continue
+		}
+		host.Server.Print(w, "  ")
+		if host.ChainErr != nil {
+			fmt.Fprintf(w, "  untrusted server cert: %s\n", host.ChainErr.Error())
+		}
+		if host.HostnameErr != nil {
+			fmt.Fprintf(w, "  hostname mismatch: %s\n", host.HostnameErr.Error())
+		}
+		if host.OK() {
+			fmt.Fprintln(w, "  ok")
+		}
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "WARNING: %s\n", warning)
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "PROBLEM: %s\n", problem)
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "All checks passed")
+	}
+}
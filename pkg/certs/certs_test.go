@@ -0,0 +1,214 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keyPair struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newKeyPair creates a certificate from the template, signed by parent, or self-signed if parent is nil
+func newKeyPair(t *testing.T, template *x509.Certificate, parent *keyPair) *keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(365 * 24 * time.Hour)
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &keyPair{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newCA(t *testing.T) *keyPair {
+	return newKeyPair(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newClient(t *testing.T, ca *keyPair, notAfter time.Time) *keyPair {
+	return newKeyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		NotAfter:    notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+func TestCheck(t *testing.T) {
+	ca := newCA(t)
+	otherCA := newCA(t)
+	client := newClient(t, ca, time.Time{})
+	other := newClient(t, ca, time.Time{})
+	expired := newKeyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		NotBefore:   time.Now().Add(-48 * time.Hour),
+		NotAfter:    time.Now().Add(-24 * time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	expiring := newClient(t, ca, time.Now().Add(24*time.Hour))
+
+	tests := []struct {
+		name         string
+		key          []byte
+		cert         []byte
+		ca           []byte
+		wantErr      bool
+		wantProblems int
+		wantWarnings int
+	}{
+		{name: "valid", key: client.keyPEM, cert: client.certPEM, ca: ca.certPEM},
+		{name: "key mismatch", key: other.keyPEM, cert: client.certPEM, ca: ca.certPEM, wantProblems: 1},
+		{name: "expired", key: expired.keyPEM, cert: expired.certPEM, ca: ca.certPEM, wantProblems: 1, wantWarnings: 1},
+		{name: "expiring", key: expiring.keyPEM, cert: expiring.certPEM, ca: ca.certPEM, wantWarnings: 1},
+		{name: "different ca", key: client.keyPEM, cert: client.certPEM, ca: otherCA.certPEM, wantWarnings: 1},
+		{name: "no ca", key: client.keyPEM, cert: client.certPEM, wantWarnings: 1},
+		{name: "bad cert", key: client.keyPEM, cert: []byte("nope"), ca: ca.certPEM, wantErr: true},
+		{name: "bad ca", key: client.keyPEM, cert: client.certPEM, ca: []byte("nope"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Check(tt.key, tt.cert, tt.ca)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, report.Problems, tt.wantProblems, report.Problems)
+			assert.Len(t, report.Warnings, tt.wantWarnings, report.Warnings)
+			assert.Equal(t, tt.wantProblems == 0, report.OK())
+			assert.Equal(t, "CN=client", report.Client.Subject)
+
+			out := &bytes.Buffer{}
+			report.Print(out)
+			assert.Contains(t, out.String(), "subject: CN=client")
+		})
+	}
+}
+
+// serve accepts TLS connections with the given server cert until the test ends, and returns its port
+func serve(t *testing.T, server *keyPair) string {
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestCheckHost(t *testing.T) {
+	ca := newCA(t)
+	client := newClient(t, ca, time.Time{})
+	port := serve(t, newKeyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "allocator"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca))
+	otherPort := serve(t, newKeyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "allocator"},
+		DNSNames:    []string{"allocator.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca))
+
+	clientCert, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name         string
+		host         string
+		serverName   string
+		roots        *x509.CertPool
+		wantErr      bool
+		wantChain    bool
+		wantHostname bool
+	}{
+		{name: "ip", host: "127.0.0.1:" + port, roots: roots},
+		{name: "hostname", host: "localhost:" + port, roots: roots},
+		{name: "untrusted", host: "127.0.0.1:" + port, roots: x509.NewCertPool(), wantChain: true},
+		{name: "hostname mismatch", host: "127.0.0.1:" + otherPort, roots: roots, wantHostname: true},
+		{name: "server name", host: "127.0.0.1:" + otherPort, serverName: "allocator.example.com", roots: roots},
+		{name: "refused", host: "127.0.0.1:1", roots: roots, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CheckHost(context.Background(), tt.host, tt.serverName, clientCert, tt.roots)
+			assert.Equal(t, tt.wantErr || tt.wantChain || tt.wantHostname, !result.OK())
+			if tt.wantErr {
+				assert.Error(t, result.Err)
+				return
+			}
+			require.NoError(t, result.Err)
+			require.NotNil(t, result.Server)
+			assert.Equal(t, "CN=allocator", result.Server.Subject)
+			assert.Equal(t, tt.wantChain, result.ChainErr != nil, result.ChainErr)
+			assert.Equal(t, tt.wantHostname, result.HostnameErr != nil, result.HostnameErr)
+		})
+	}
+}
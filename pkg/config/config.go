@@ -0,0 +1,231 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix is put in front of every generated environment variable
+const EnvPrefix = "AGONES_"
+
+// File is the contents of the config file
+type File struct {
+	// CurrentProfile is used when no profile is asked for
+	CurrentProfile string `json:"currentProfile,omitempty"`
+	// Profiles are sets of flag values, by profile name
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Profile maps flag names to values. Values can be strings, numbers, booleans, lists for
+// list flags, or maps for map flags.
+type Profile map[string]interface{}
+
+// DefaultPath returns where the config file is looked for when no path is given
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "agones-allocator-client", "config.yaml")
+}
+
+// Load reads a config file. If the file does not exist and optional is true, an empty config is returned.
+func Load(path string, optional bool) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return &File{}, nil
+		}
+		return nil, err
+	}
+	file := &File{}
+	err = yaml.UnmarshalStrict(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %s - %s", path, err.Error())
+	}
+	return file, nil
+}
+
+// Profile returns the named profile, or the current profile if name is empty. If there is no
+// current profile either, an empty profile is returned.
+func (f *File) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = f.CurrentProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s is not in the config file", name)
+	}
+	return profile, nil
+}
+
+// Validate returns an error if the profile has a key that is not a flag in any of the flag sets.
+// Profiles are shared between commands, so keys for other commands are fine.
+func (p Profile) Validate(flagSets ...*pflag.FlagSet) error {
+	for _, name := range sortedNames(p) {
+		found := false
+		for _, flags := range flagSets {
+			if flags.Lookup(name) != nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown flag %s in profile", name)
+		}
+	}
+	return nil
+}
+
+func sortedNames(m map[string]interface{}) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnvName returns the environment variable for a flag, e.g. AGONES_MAX_RETRIES for --max-retries
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Apply sets every flag that was not given on the command line, first from the environment and
+// then from the profile, so that flags win over the environment, and the environment over the profile.
+// Profile keys that are not in flags are ignored. envNames maps flag names to environment
+// variables that don't follow EnvName.
+func Apply(flags *pflag.FlagSet, profile Profile, envNames map[string]string) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+		env, value := lookupEnv(flag.Name, envNames)
+		if value != "" {
+			if setErr := flag.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("could not set %s from environment variable %s - %s", flag.Name, env, setErr.Error())
+			}
+			return
+		}
+		if value, ok := profile[flag.Name]; ok {
+			if setErr := setFromProfile(flag, value); setErr != nil {
+				err = fmt.Errorf("could not set %s from profile - %s", flag.Name, setErr.Error())
+			}
+		}
+	})
+	return err
+}
+
+// WithoutConflicts returns a copy of the profile without the keys that clash with a flag given on
+// the command line or in the environment. Each group in exclusive is a set of flags of which only
+// one may be set, so that e.g. --hosts-ping replaces the hosts of a profile instead of clashing
+// with them.
+func (p Profile) WithoutConflicts(flags *pflag.FlagSet, envNames map[string]string, exclusive ...[]string) Profile {
+	result := Profile{}
+	for name, value := range p {
+		result[name] = value
+	}
+	for _, group := range exclusive {
+		for _, name := range group {
+			if !setExplicitly(flags, name, envNames) {
+				continue
+			}
+			for _, other := range group {
+				if other != name {
+					delete(result, other)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// setExplicitly is true if a flag was given on the command line or in the environment
+func setExplicitly(flags *pflag.FlagSet, name string, envNames map[string]string) bool {
+	flag := flags.Lookup(name)
+	if flag == nil {
+		return false
+	}
+	_, value := lookupEnv(name, envNames)
+	return flag.Changed || value != ""
+}
+
+// lookupEnv returns the environment variable for a flag and its value
+func lookupEnv(name string, envNames map[string]string) (string, string) {
+	env, ok := envNames[name]
+	if !ok {
+		env = EnvName(name)
+	}
+	return env, os.Getenv(env)
+}
+
+// setFromProfile sets a flag from a YAML value
+func setFromProfile(flag *pflag.Flag, value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, scalar(item))
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			return slice.Replace(values)
+		}
+		return flag.Value.Set(strings.Join(values, ","))
+	case map[string]interface{}:
+		// Map flags replace their value on the first Set and merge after that
+		for _, key := range sortedNames(v) {
+			err := flag.Value.Set(fmt.Sprintf("%s=%s", key, scalar(v[key])))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return flag.Value.Set(scalar(v))
+	}
+}
+
+// scalar formats a single YAML value as a flag would be written. Paths starting with ~/ are expanded.
+func scalar(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if strings.HasPrefix(v, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				return filepath.Join(home, v[2:])
+			}
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
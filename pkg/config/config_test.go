@@ -0,0 +1,180 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+currentProfile: us-east
+profiles:
+  us-east:
+    hosts:
+    - allocator.us-east.example.com
+    - allocator2.us-east.example.com
+    namespace: east
+    max-retries: 3
+    multicluster: true
+    labels-required:
+      agones.dev/fleet: simple-udp
+  eu-west:
+    hosts-ping:
+      allocator.eu-west.example.com: ping.eu-west.example.com
+    namespace: west
+`
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	file, err := Load(writeConfig(t, testConfig), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east", file.CurrentProfile)
+	assert.Len(t, file.Profiles, 2)
+
+	_, err = Load(writeConfig(t, "profiles: {}\nunknown: true\n"), false)
+	assert.Error(t, err, "unknown top level keys should be an error")
+
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	file, err = Load(missing, true)
+	assert.NoError(t, err)
+	assert.Empty(t, file.Profiles)
+	_, err = Load(missing, false)
+	assert.Error(t, err)
+}
+
+func TestFile_Profile(t *testing.T) {
+	file, err := Load(writeConfig(t, testConfig), false)
+	require.NoError(t, err)
+
+	profile, err := file.Profile("")
+	assert.NoError(t, err)
+	assert.Equal(t, "east", profile["namespace"])
+
+	profile, err = file.Profile("eu-west")
+	assert.NoError(t, err)
+	assert.Equal(t, "west", profile["namespace"])
+
+	_, err = file.Profile("ap-south")
+	assert.Error(t, err)
+
+	profile, err = (&File{}).Profile("")
+	assert.NoError(t, err)
+	assert.Empty(t, profile)
+}
+
+type testFlags struct {
+	flags        *pflag.FlagSet
+	hosts        []string
+	pingServers  map[string]string
+	namespace    string
+	maxRetries   int
+	multicluster bool
+	labels       map[string]string
+	cert         string
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{flags: pflag.NewFlagSet("test", pflag.ContinueOnError)}
+	f.flags.StringSliceVar(&f.hosts, "hosts", nil, "")
+	f.flags.StringToStringVar(&f.pingServers, "hosts-ping", nil, "")
+	f.flags.StringVar(&f.namespace, "namespace", "default", "")
+	f.flags.IntVar(&f.maxRetries, "max-retries", 10, "")
+	f.flags.BoolVar(&f.multicluster, "multicluster", false, "")
+	f.flags.StringToStringVar(&f.labels, "labels-required", nil, "")
+	f.flags.StringVar(&f.cert, "cert", "", "")
+	return f
+}
+
+func TestApply(t *testing.T) {
+	file, err := Load(writeConfig(t, testConfig), false)
+	require.NoError(t, err)
+	profile, err := file.Profile("")
+	require.NoError(t, err)
+
+	f := newTestFlags()
+	require.NoError(t, f.flags.Parse([]string{"--max-retries", "5"}))
+	os.Setenv("AGONES_GS_NAMESPACE", "from-env")
+	defer os.Unsetenv("AGONES_GS_NAMESPACE")
+	os.Setenv("AGONES_CERT", "/env/client.crt")
+	defer os.Unsetenv("AGONES_CERT")
+
+	err = Apply(f.flags, profile, map[string]string{"namespace": "AGONES_GS_NAMESPACE"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, f.maxRetries, "flags win over the profile")
+	assert.Equal(t, "from-env", f.namespace, "the environment wins over the profile")
+	assert.Equal(t, "/env/client.crt", f.cert)
+	assert.Equal(t, []string{"allocator.us-east.example.com", "allocator2.us-east.example.com"}, f.hosts)
+	assert.True(t, f.multicluster)
+	assert.Equal(t, map[string]string{"agones.dev/fleet": "simple-udp"}, f.labels)
+	assert.Nil(t, f.pingServers)
+
+	f = newTestFlags()
+	err = Apply(f.flags, Profile{"max-retries": "lots"}, nil)
+	assert.Error(t, err)
+}
+
+func TestProfile_WithoutConflicts(t *testing.T) {
+	profile := Profile{
+		"hosts":     []interface{}{"allocator.us-east.example.com"},
+		"namespace": "east",
+	}
+	exclusive := []string{"hosts", "hosts-ping"}
+
+	f := newTestFlags()
+	require.NoError(t, f.flags.Parse([]string{"--hosts-ping", "allocator.eu-west.example.com=ping.eu-west.example.com"}))
+	got := profile.WithoutConflicts(f.flags, nil, exclusive)
+	assert.Equal(t, Profile{"namespace": "east"}, got)
+	assert.Len(t, profile, 2, "the profile is not changed")
+	require.NoError(t, Apply(f.flags, got, nil))
+	assert.Nil(t, f.hosts)
+	assert.Equal(t, map[string]string{"allocator.eu-west.example.com": "ping.eu-west.example.com"}, f.pingServers)
+
+	f = newTestFlags()
+	os.Setenv("AGONES_PING", "allocator.eu-west.example.com=ping.eu-west.example.com")
+	defer os.Unsetenv("AGONES_PING")
+	assert.Equal(t, Profile{"namespace": "east"}, profile.WithoutConflicts(f.flags, map[string]string{"hosts-ping": "AGONES_PING"}, exclusive))
+
+	f = newTestFlags()
+	require.NoError(t, f.flags.Parse([]string{"--hosts", "allocator.example.com"}))
+	assert.Equal(t, profile, profile.WithoutConflicts(f.flags, nil, exclusive), "a flag does not conflict with itself")
+}
+
+func TestProfile_Validate(t *testing.T) {
+	f := newTestFlags()
+	other := pflag.NewFlagSet("other", pflag.ContinueOnError)
+	other.Int("count", 10, "")
+
+	assert.NoError(t, Profile{"hosts": []interface{}{"a"}, "count": 1.0}.Validate(f.flags, other))
+	assert.Error(t, Profile{"hostz": []interface{}{"a"}}.Validate(f.flags, other))
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "AGONES_MAX_RETRIES", EnvName("max-retries"))
+	assert.Equal(t, "AGONES_HOSTS", EnvName("hosts"))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+// Package dashboard draws a live view of a running load test in the terminal
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+// Dashboard periodically redraws the progress of a load test
+type Dashboard struct {
+	// Out is where the dashboard is drawn
+	Out io.Writer
+	// Interval is how often the dashboard is redrawn
+	Interval time.Duration
+	// Progress returns the current state of the test
+	Progress func() allocator.Progress
+
+	// lines is the height of the last frame, so it can be cleared
+	lines int
+}
+
+// IsTerminal returns true if the file is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Run redraws the dashboard until the context is cancelled, then draws a final frame
+func (d *Dashboard) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.draw()
+			return
+		case <-ticker.C:
+			d.draw()
+		}
+	}
+}
+
+func (d *Dashboard) draw() {
+	frame := Render(d.Progress())
+	if d.lines > 0 {
+		// Move back to the top of the previous frame and clear it
+		fmt.Fprintf(d.Out, "\033[%dA\033[J", d.lines)
+	}
+	fmt.Fprint(d.Out, strings.Join(frame, "\n")+"\n")
+	d.lines = len(frame)
+}
+
+// Render formats the progress of a load test as lines of text
+func Render(p allocator.Progress) []string {
+	lines := []string{
+		fmt.Sprintf("elapsed %s | active sessions %d | started %d | allocated %d",
+			p.Elapsed.Round(time.Second), p.ActiveSessions, p.SessionsStarted, p.Allocations),
+		fmt.Sprintf("last %s: %.2f allocations/s | error rate %.1f%% | latency p50 %s p90 %s p99 %s",
+			p.Window.Round(time.Second), p.AllocationsPerSecond, p.ErrorRate*100, round(p.P50), round(p.P90), round(p.P99)),
+	}
+
+	if p.QueueWaitP99 > 0 {
+		lines = append(lines, fmt.Sprintf("client queue wait p50 %s p99 %s", round(p.QueueWaitP50), round(p.QueueWaitP99)))
+	}
+
+	codes := make([]string, 0, len(p.Codes))
+	for code, count := range p.Codes {
+		codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+	}
+	sort.Strings(codes)
+	if len(codes) > 0 {
+		lines = append(lines, fmt.Sprintf("codes: %s", strings.Join(codes, " ")))
+	}
+
+	endpoints := make([]string, 0, len(p.Endpoints))
+	for name := range p.Endpoints {
+		endpoints = append(endpoints, name)
+	}
+	sort.Strings(endpoints)
+	for _, name := range endpoints {
+		e := p.Endpoints[name]
+		line := fmt.Sprintf("  %-40s %5d attempts %5d errors  p50 %s", name, e.Attempts, e.Errors, round(e.P50))
+		if e.Circuit != "" && e.Circuit != allocator.BreakerClosed {
+			line += fmt.Sprintf("  circuit %s", e.Circuit)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+func TestRender(t *testing.T) {
+	progress := allocator.Progress{
+		Elapsed:              90 * time.Second,
+		Window:               30 * time.Second,
+		ActiveSessions:       4,
+		SessionsStarted:      10,
+		Allocations:          8,
+		AllocationsPerSecond: 0.25,
+		ErrorRate:            0.2,
+		Codes:                map[string]int{"OK": 8, "ResourceExhausted": 2},
+		P50:                  12 * time.Millisecond,
+		P90:                  40 * time.Millisecond,
+		P99:                  95 * time.Millisecond,
+		Endpoints: map[string]allocator.EndpointProgress{
+			"west:443": {Attempts: 4, P50: 20 * time.Millisecond},
+			"east:443": {Attempts: 6, Errors: 2, P50: 10 * time.Millisecond},
+		},
+	}
+	want := []string{
+		"elapsed 1m30s | active sessions 4 | started 10 | allocated 8",
+		"last 30s: 0.25 allocations/s | error rate 20.0% | latency p50 12ms p90 40ms p99 95ms",
+		"codes: OK=8 ResourceExhausted=2",
+		"  east:443                                     6 attempts     2 errors  p50 10ms",
+		"  west:443                                     4 attempts     0 errors  p50 20ms",
+	}
+	assert.Equal(t, want, Render(progress))
+}
+
+func TestRender_queueWait(t *testing.T) {
+	progress := allocator.Progress{
+		Elapsed:      10 * time.Second,
+		Window:       10 * time.Second,
+		QueueWaitP50: 2 * time.Millisecond,
+		QueueWaitP99: 250 * time.Millisecond,
+	}
+	lines := Render(progress)
+	assert.Contains(t, lines, "client queue wait p50 2ms p99 250ms")
+}
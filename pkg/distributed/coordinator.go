@@ -0,0 +1,229 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+// stopTimeout is how long to wait for a worker to acknowledge a stop request
+const stopTimeout = 5 * time.Second
+
+// runTimeoutMargin is added to the expected length of a slice when estimating how long a
+// worker may take, to leave room for allocation retries and slow sessions
+const runTimeoutMargin = 5 * time.Minute
+
+// Report is the merged result of a distributed load test
+type Report struct {
+	Total   allocator.LoadSummary `json:"total"`
+	Workers []WorkerResult        `json:"workers"`
+}
+
+// Print writes a human readable summary of the report
+func (r *Report) Print(w io.Writer) {
+	r.Total.Print(w)
+	fmt.Fprintln(w, "Workers:")
+	for _, result := range r.Workers {
+		if result.Error != "" {
+			fmt.Fprintf(w, "  %s: error - %s\n", result.Worker, result.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %d sessions, %d allocations, %d completed, %d failed\n",
+			result.Worker, result.Summary.SessionsStarted, result.Summary.Allocations, result.Summary.SessionsCompleted, result.Summary.SessionsFailed)
+	}
+}
+
+// Coordinator splits a load test across a set of workers
+type Coordinator struct {
+	// Workers is the list of worker base URLs, e.g. http://10.0.0.1:8080
+	Workers []string
+	// Token is the shared secret the workers were started with
+	Token string
+	// HTTPClient is used to talk to the workers. If nil, a client with the Timeout is used.
+	HTTPClient *http.Client
+	// Timeout bounds each worker's run, since a worker does not respond until its slice of the
+	// test is done. If zero, it is estimated from the scenario, with a few minutes to spare.
+	Timeout time.Duration
+}
+
+// SplitScenario divides a load test into n slices. Sessions are shared out as evenly as
+// possible, and the delay between sessions on each worker is stretched so that the
+// combined rate of new sessions matches the original scenario.
+func SplitScenario(opts allocator.LoadOptions, n int) []allocator.LoadOptions {
+	slices := make([]allocator.LoadOptions, n)
+	for i := range slices {
+		slice := opts
+		slice.Count = opts.Count / n
+		if i < opts.Count%n {
+			slice.Count++
+		}
+		slice.Delay = opts.Delay * time.Duration(n)
+		slices[i] = slice
+	}
+	return slices
+}
+
+// Run hands a slice of the scenario to every worker and waits for them all to finish.
+// Cancelling the context asks the workers to stop, and their partial results are still
+// collected from the workers that report back within the grace period.
+func (c *Coordinator) Run(ctx context.Context, opts allocator.LoadOptions) (*Report, error) {
+	if len(c.Workers) < 1 {
+		return nil, fmt.Errorf("you must pass at least one worker")
+	}
+	slices := SplitScenario(opts, len(c.Workers))
+	timeout := c.Timeout
+	if timeout == 0 {
+		// The first slice is never smaller than the others
+		timeout = slices[0].Delay*time.Duration(slices[0].Count) + opts.Duration + opts.GracePeriod + runTimeoutMargin
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	// The runs are not cancelled along with ctx, so that stopped workers can still send back
+	// their partial results
+	runCtx, cancelRuns := context.WithTimeout(context.Background(), timeout)
+	defer cancelRuns()
+	results := make([]WorkerResult, len(c.Workers))
+	var wg sync.WaitGroup
+	for i, worker := range c.Workers {
+		wg.Add(1)
+		go func(i int, worker string) {
+			defer wg.Done()
+			results[i] = c.runWorker(runCtx, httpClient, worker, slices[i])
+		}(i, worker)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		klog.Warning("stopping workers")
+		for _, worker := range c.Workers {
+			c.stopWorker(httpClient, worker)
+		}
+		wait := opts.GracePeriod + stopTimeout
+		select {
+		case <-done:
+		case <-time.After(wait):
+			klog.Warningf("not every worker stopped within %s - giving up on them", wait)
+			cancelRuns()
+			<-done
+		}
+	}
+
+	report := &Report{
+		Workers: results,
+	}
+	summaries := []allocator.LoadSummary{}
+	for _, result := range results {
+		if result.Error != "" {
+			klog.Errorf("worker %s - %s", result.Worker, result.Error)
+		}
+		summaries = append(summaries, result.Summary)
+	}
+	report.Total = allocator.MergeLoadSummaries(summaries...)
+	return report, nil
+}
+
+func (c *Coordinator) runWorker(ctx context.Context, httpClient *http.Client, worker string, opts allocator.LoadOptions) WorkerResult {
+	result := WorkerResult{
+		Worker: worker,
+	}
+	body, err := json.Marshal(opts)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	klog.V(2).Infof("sending %d sessions to worker %s", opts.Count, worker)
+	req, err := c.newRequest(ctx, workerURL(worker, runPath), bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		result.Error = fmt.Sprintf("worker returned %s - %s", resp.Status, strings.TrimSpace(string(message)))
+		return result
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (c *Coordinator) stopWorker(httpClient *http.Client, worker string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	req, err := c.newRequest(ctx, workerURL(worker, stopPath), nil)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		klog.Errorf("could not stop worker %s - %s", worker, err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+// newRequest builds a POST to a worker, authenticated with the token
+func (c *Coordinator) newRequest(ctx context.Context, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", bearerPrefix+c.Token)
+	return req, nil
+}
+
+func workerURL(worker, path string) string {
+	if !strings.Contains(worker, "://") {
+		worker = fmt.Sprintf("http://%s", worker)
+	}
+	return strings.TrimSuffix(worker, "/") + path
+}
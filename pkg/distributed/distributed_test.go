@@ -0,0 +1,202 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package distributed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+func TestSplitScenario(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   allocator.LoadOptions
+		n      int
+		counts []int
+		delay  time.Duration
+	}{
+		{
+			name:   "one worker",
+			opts:   allocator.LoadOptions{Count: 10, Delay: time.Second},
+			n:      1,
+			counts: []int{10},
+			delay:  time.Second,
+		},
+		{
+			name:   "uneven",
+			opts:   allocator.LoadOptions{Count: 10, Delay: time.Second},
+			n:      3,
+			counts: []int{4, 3, 3},
+			delay:  3 * time.Second,
+		},
+		{
+			name:   "more workers than sessions",
+			opts:   allocator.LoadOptions{Count: 1},
+			n:      2,
+			counts: []int{1, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitScenario(tt.opts, tt.n)
+			counts := []int{}
+			for _, slice := range got {
+				counts = append(counts, slice.Count)
+				assert.Equal(t, tt.delay, slice.Delay)
+			}
+			assert.Equal(t, tt.counts, counts)
+		})
+	}
+}
+
+func fakeRunner(ctx context.Context, opts allocator.LoadOptions) (allocator.LoadSummary, error) {
+	summary := allocator.LoadSummary{
+		Start:           time.Now(),
+		SessionsStarted: opts.Count,
+		Allocations:     opts.Count,
+	}
+	select {
+	case <-ctx.Done():
+		summary.Interrupted = true
+		summary.SessionsInterrupted = opts.Count
+	case <-time.After(opts.Duration):
+		summary.SessionsCompleted = opts.Count
+	}
+	summary.End = time.Now()
+	return summary, nil
+}
+
+func TestCoordinator_Run(t *testing.T) {
+	east := httptest.NewServer((&Worker{Name: "east", Run: fakeRunner, Token: "secret"}).Handler())
+	defer east.Close()
+	west := httptest.NewServer((&Worker{Name: "west", Run: fakeRunner, Token: "secret"}).Handler())
+	defer west.Close()
+
+	coordinator := &Coordinator{
+		Workers: []string{east.URL, west.URL, "127.0.0.1:1"},
+		Token:   "secret",
+	}
+	report, err := coordinator.Run(context.Background(), allocator.LoadOptions{Count: 5})
+	assert.NoError(t, err)
+	assert.Len(t, report.Workers, 3)
+	assert.Equal(t, "east", report.Workers[0].Worker)
+	assert.Equal(t, "west", report.Workers[1].Worker)
+	assert.NotEmpty(t, report.Workers[2].Error)
+	assert.Equal(t, 4, report.Total.SessionsStarted)
+	assert.Equal(t, 4, report.Total.SessionsCompleted)
+	assert.False(t, report.Total.Interrupted)
+}
+
+func TestCoordinator_RunInterrupted(t *testing.T) {
+	worker := httptest.NewServer((&Worker{Name: "worker", Run: fakeRunner, Token: "secret"}).Handler())
+	defer worker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	coordinator := &Coordinator{
+		Workers: []string{worker.URL},
+		Token:   "secret",
+	}
+	report, err := coordinator.Run(ctx, allocator.LoadOptions{Count: 2, Duration: time.Minute})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Workers[0].Error)
+	assert.True(t, report.Total.Interrupted)
+	assert.Equal(t, 2, report.Total.SessionsInterrupted)
+}
+
+// hungWorker starts a worker whose runs ignore being stopped, until the test ends
+func hungWorker(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	hung := func(ctx context.Context, opts allocator.LoadOptions) (allocator.LoadSummary, error) {
+		<-release
+		return allocator.LoadSummary{}, nil
+	}
+	worker := httptest.NewServer((&Worker{Name: "hung", Run: hung, Token: "secret"}).Handler())
+	t.Cleanup(worker.Close)
+	t.Cleanup(func() { close(release) })
+	return worker
+}
+
+func TestCoordinator_RunTimeout(t *testing.T) {
+	worker := hungWorker(t)
+	coordinator := &Coordinator{
+		Workers: []string{worker.URL},
+		Token:   "secret",
+		Timeout: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	report, err := coordinator.Run(context.Background(), allocator.LoadOptions{Count: 1})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, report.Workers[0].Error)
+	assert.Less(t, int64(time.Since(start)), int64(stopTimeout))
+}
+
+func TestCoordinator_RunStopIgnored(t *testing.T) {
+	worker := hungWorker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	coordinator := &Coordinator{
+		Workers: []string{worker.URL},
+		Token:   "secret",
+	}
+	start := time.Now()
+	report, err := coordinator.Run(ctx, allocator.LoadOptions{Count: 1, Duration: time.Minute})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, report.Workers[0].Error)
+	assert.Less(t, int64(time.Since(start)), int64(2*stopTimeout))
+}
+
+func TestWorker_authorize(t *testing.T) {
+	tests := []struct {
+		name        string
+		workerToken string
+		token       string
+		path        string
+		wantStatus  int
+	}{
+		{name: "right token", workerToken: "secret", token: "secret", path: stopPath, wantStatus: http.StatusAccepted},
+		{name: "wrong token", workerToken: "secret", token: "guess", path: stopPath, wantStatus: http.StatusUnauthorized},
+		{name: "no token", workerToken: "secret", path: runPath, wantStatus: http.StatusUnauthorized},
+		{name: "worker without a token refuses everything", token: "", path: runPath, wantStatus: http.StatusUnauthorized},
+		{name: "health checks are open", workerToken: "secret", path: healthPath, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := httptest.NewServer((&Worker{Name: "worker", Run: fakeRunner, Token: tt.workerToken}).Handler())
+			defer worker.Close()
+			req, err := (&Coordinator{Token: tt.token}).newRequest(context.Background(), worker.URL+tt.path, nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+
+	err := (&Worker{Name: "worker", Run: fakeRunner}).ListenAndServe(context.Background(), "127.0.0.1:0")
+	assert.Error(t, err, "a worker without a token does not start")
+}
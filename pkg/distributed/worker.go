@@ -0,0 +1,177 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+// Package distributed splits a load test across several worker processes. A
+// coordinator hands each worker a slice of the scenario over HTTP, the workers
+// run it locally, and the coordinator merges the results.
+package distributed
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+
+	"github.com/fairwindsops/agones-allocator-client/pkg/allocator"
+)
+
+const (
+	runPath    = "/run"
+	stopPath   = "/stop"
+	healthPath = "/healthz"
+
+	bearerPrefix = "Bearer "
+)
+
+// Runner runs a slice of a load test and returns its results
+type Runner func(ctx context.Context, opts allocator.LoadOptions) (allocator.LoadSummary, error)
+
+// WorkerResult is what a worker sends back to the coordinator
+type WorkerResult struct {
+	Worker  string                `json:"worker"`
+	Summary allocator.LoadSummary `json:"summary"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// Worker runs load test scenarios handed to it by a coordinator, one at a time
+type Worker struct {
+	// Name identifies the worker in the coordinator's report
+	Name string
+	// Run runs the scenario
+	Run Runner
+	// Token is the shared secret the coordinator must send as a bearer token. A scenario spends
+	// the worker's allocator credentials, so without a token every run and stop is refused.
+	Token string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// Handler returns the HTTP handler for the worker API
+func (w *Worker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(runPath, w.authorize(w.handleRun))
+	mux.HandleFunc(stopPath, w.authorize(w.handleStop))
+	mux.HandleFunc(healthPath, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// authorize only lets requests with the worker's token through to the handler
+func (w *Worker) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), bearerPrefix)
+		if w.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(w.Token)) != 1 {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(rw, r)
+	}
+}
+
+// ListenAndServe serves the worker API until the context is cancelled
+func (w *Worker) ListenAndServe(ctx context.Context, addr string) error {
+	if w.Token == "" {
+		return fmt.Errorf("the worker needs a token to authenticate the coordinator")
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: w.Handler(),
+	}
+	go func() {
+		<-ctx.Done()
+		w.stop()
+		_ = server.Shutdown(context.Background())
+	}()
+	klog.Infof("worker %s listening on %s", w.Name, addr)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (w *Worker) handleRun(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts := allocator.LoadOptions{}
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The scenario is stopped if the coordinator goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	w.mu.Lock()
+	if w.cancel != nil {
+		w.mu.Unlock()
+		http.Error(rw, "worker is already running a scenario", http.StatusConflict)
+		return
+	}
+	w.cancel = cancel
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.cancel = nil
+		w.mu.Unlock()
+	}()
+
+	klog.Infof("worker %s starting %d sessions", w.Name, opts.Count)
+	summary, err := w.Run(ctx, opts)
+	result := WorkerResult{
+		Worker:  w.Name,
+		Summary: summary,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	klog.Infof("worker %s finished", w.Name)
+
+	rw.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(rw).Encode(result)
+	if err != nil {
+		klog.Error(err)
+	}
+}
+
+func (w *Worker) handleStop(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.stop()
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// stop interrupts the running scenario, if there is one
+func (w *Worker) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		klog.Infof("worker %s stopping", w.Name)
+		w.cancel()
+	}
+}
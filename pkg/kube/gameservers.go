@@ -0,0 +1,71 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package kube
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// RunIDLabel is the label the client puts on the gameservers it allocates, so that a run's
+// gameservers can be found and cleaned up afterwards
+const RunIDLabel = "agones-allocator-client/run-id"
+
+// shutdownPatch moves a gameserver to the Shutdown state, as the SDK's Shutdown() does
+var shutdownPatch = []byte(`{"status":{"state":"Shutdown"}}`)
+
+// RunIDSelector returns the label selector for the gameservers of a run
+func RunIDSelector(runID string) string {
+	return labels.Set{RunIDLabel: runID}.String()
+}
+
+// ListGameServers returns the names of the gameservers in the namespace that match the label selector
+func (c *Client) ListGameServers(ctx context.Context, namespace string, selector string) ([]string, error) {
+	list, err := c.Dynamic.Resource(gameServerResource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	return names, nil
+}
+
+// ShutdownGameServers marks a list of gameservers as Shutdown, so that Agones removes them the
+// same way as when the game process calls Shutdown(). Gameservers that no longer exist are ignored.
+// All of the updates are attempted, and the first error is returned.
+func (c *Client) ShutdownGameServers(ctx context.Context, namespace string, names []string) error {
+	var firstErr error
+	for _, name := range names {
+		_, err := c.Dynamic.Resource(gameServerResource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, shutdownPatch, metav1.PatchOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("could not shut down gameserver %s/%s - %s", namespace, name, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		klog.V(2).Infof("shut down gameserver %s/%s", namespace, name)
+	}
+	return firstErr
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package kube
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+// gameServerResource is the Agones GameServer custom resource
+var gameServerResource = schema.GroupVersionResource{
+	Group:    "agones.dev",
+	Version:  "v1",
+	Resource: "gameservers",
+}
+
+// Client talks to the kubernetes cluster that hosts the allocator
+type Client struct {
+	// Config is the rest config built from the kubeconfig
+	Config *rest.Config
+	// Dynamic is used to manage Agones resources
+	Dynamic dynamic.Interface
+	// Clientset is used to read core resources, such as secrets and services
+	Clientset kubernetes.Interface
+}
+
+// NewClient builds a client from a kubeconfig file and context. If kubeconfig is empty, the
+// usual loading rules apply ($KUBECONFIG, ~/.kube/config, or the in-cluster config).
+// If kubeContext is empty, the current context is used.
+func NewClient(kubeconfig, kubeContext string) (*Client, error) {
+	config, err := getConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Config:    config,
+		Dynamic:   dynamicClient,
+		Clientset: clientset,
+	}, nil
+}
+
+func getConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// DeleteGameServers deletes a list of gameservers by name. Gameservers that no longer exist are ignored.
+// All of the deletes are attempted, and the first error is returned.
+func (c *Client) DeleteGameServers(ctx context.Context, namespace string, names []string) error {
+	var firstErr error
+	for _, name := range names {
+		err := c.Dynamic.Resource(gameServerResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("could not delete gameserver %s/%s - %s", namespace, name, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		klog.V(2).Infof("deleted gameserver %s/%s", namespace, name)
+	}
+	return firstErr
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		want    SecretRef
+		wantErr bool
+	}{
+		{
+			name: "name only",
+			ref:  "allocator-tls-ca",
+			want: SecretRef{Namespace: "agones-system", Name: "allocator-tls-ca"},
+		},
+		{
+			name: "namespace and name",
+			ref:  "default/allocator-client.default",
+			want: SecretRef{Namespace: "default", Name: "allocator-client.default"},
+		},
+		{
+			name:    "empty",
+			ref:     "",
+			wantErr: true,
+		},
+		{
+			name:    "too many parts",
+			ref:     "a/b/c",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecretRef(tt.ref, DefaultAgonesNamespace)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClient_Credentials(t *testing.T) {
+	client := &Client{
+		Clientset: fake.NewSimpleClientset(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "allocator-client.default", Namespace: "default"},
+				Data: map[string][]byte{
+					"tls.crt": []byte("cert"),
+					"tls.key": []byte("key"),
+				},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "allocator-tls-ca", Namespace: "agones-system"},
+				Data: map[string][]byte{
+					"tls-ca.crt": []byte("ca"),
+				},
+			},
+		),
+	}
+	ctx := context.Background()
+
+	key, cert, err := client.ClientCredentials(ctx, SecretRef{Namespace: "default", Name: "allocator-client.default"})
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(key))
+	assert.Equal(t, "cert", string(cert))
+
+	ca, err := client.CACertificate(ctx, SecretRef{Namespace: "agones-system", Name: "allocator-tls-ca"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ca", string(ca))
+
+	_, err = client.CACertificate(ctx, SecretRef{Namespace: "default", Name: "allocator-client.default"})
+	assert.Error(t, err)
+
+	_, _, err = client.ClientCredentials(ctx, SecretRef{Namespace: "default", Name: "missing"})
+	assert.Error(t, err)
+}
+
+func TestClient_AllocatorAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *corev1.Service
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "ip",
+			service: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "agones-allocator", Namespace: "agones-system"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 443}}},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{{IP: "34.1.2.3"}},
+				}},
+			},
+			want: "34.1.2.3:443",
+		},
+		{
+			name: "hostname",
+			service: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "agones-allocator", Namespace: "agones-system"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8443}}},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{{Hostname: "allocator.elb.example.com"}},
+				}},
+			},
+			want: "allocator.elb.example.com:8443",
+		},
+		{
+			name: "pending",
+			service: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "agones-allocator", Namespace: "agones-system"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{Clientset: fake.NewSimpleClientset(tt.service)}
+			got, err := client.AllocatorAddress(context.Background(), DefaultAgonesNamespace, DefaultAllocatorService)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func newGameServer(namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	gs := &unstructured.Unstructured{}
+	gs.SetAPIVersion("agones.dev/v1")
+	gs.SetKind("GameServer")
+	gs.SetNamespace(namespace)
+	gs.SetName(name)
+	gs.SetLabels(labels)
+	_ = unstructured.SetNestedField(gs.Object, "Allocated", "status", "state")
+	return gs
+}
+
+func newFakeDynamicClient() *Client {
+	run := map[string]string{RunIDLabel: "run-1"}
+	return &Client{
+		Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{gameServerResource: "GameServerList"},
+			newGameServer("default", "gs-1", run),
+			newGameServer("default", "gs-2", run),
+			newGameServer("default", "gs-3", map[string]string{RunIDLabel: "run-2"}),
+			newGameServer("other", "gs-4", run),
+		),
+	}
+}
+
+func TestClient_ListGameServers(t *testing.T) {
+	client := newFakeDynamicClient()
+	names, err := client.ListGameServers(context.Background(), "default", RunIDSelector("run-1"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"gs-1", "gs-2"}, names)
+
+	names, err = client.ListGameServers(context.Background(), "default", RunIDSelector("run-3"))
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestClient_ShutdownGameServers(t *testing.T) {
+	client := newFakeDynamicClient()
+	err := client.ShutdownGameServers(context.Background(), "default", []string{"gs-1", "missing"})
+	assert.NoError(t, err)
+
+	resource := client.Dynamic.Resource(gameServerResource).Namespace("default")
+	gs, err := resource.Get(context.Background(), "gs-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	state, _, _ := unstructured.NestedString(gs.Object, "status", "state")
+	assert.Equal(t, "Shutdown", state)
+
+	gs, err = resource.Get(context.Background(), "gs-2", metav1.GetOptions{})
+	assert.NoError(t, err)
+	state, _, _ = unstructured.NestedString(gs.Object, "status", "state")
+	assert.Equal(t, "Allocated", state)
+}
+
+func TestClient_DeleteGameServers(t *testing.T) {
+	client := newFakeDynamicClient()
+	err := client.DeleteGameServers(context.Background(), "default", []string{"gs-1", "gs-2", "missing"})
+	assert.NoError(t, err)
+
+	names, err := client.ListGameServers(context.Background(), "default", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gs-3"}, names)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultAgonesNamespace is where Agones installs the allocator
+	DefaultAgonesNamespace = "agones-system"
+	// DefaultAllocatorService is the service that exposes the allocator
+	DefaultAllocatorService = "agones-allocator"
+)
+
+// caKeys are the keys that may hold the CA certificate in a secret, in order of preference
+var caKeys = []string{"tls-ca.crt", "ca.crt"}
+
+// SecretRef points to a secret. A reference is written as namespace/name, or just name
+// to use a default namespace.
+type SecretRef struct {
+	Namespace string
+	Name      string
+}
+
+// ParseSecretRef parses a namespace/name reference
+func ParseSecretRef(ref, defaultNamespace string) (SecretRef, error) {
+	parts := strings.Split(ref, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return SecretRef{Namespace: defaultNamespace, Name: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return SecretRef{Namespace: parts[0], Name: parts[1]}, nil
+	default:
+		return SecretRef{}, fmt.Errorf("secret reference %q must be either name or namespace/name", ref)
+	}
+}
+
+func (r SecretRef) String() string {
+	return fmt.Sprintf("%s/%s", r.Namespace, r.Name)
+}
+
+// ClientCredentials reads the client key and certificate from a TLS secret,
+// such as allocator-client.default
+func (c *Client) ClientCredentials(ctx context.Context, ref SecretRef) (key []byte, cert []byte, err error) {
+	secret, err := c.getSecret(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := secret.Data[corev1.TLSPrivateKeyKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("secret %s has no %s", ref, corev1.TLSPrivateKeyKey)
+	}
+	cert, ok = secret.Data[corev1.TLSCertKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("secret %s has no %s", ref, corev1.TLSCertKey)
+	}
+	return key, cert, nil
+}
+
+// CACertificate reads the allocator's CA certificate from a secret, such as allocator-tls-ca
+func (c *Client) CACertificate(ctx context.Context, ref SecretRef) ([]byte, error) {
+	secret, err := c.getSecret(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range caKeys {
+		if ca, ok := secret.Data[key]; ok {
+			return ca, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %s has none of %s", ref, strings.Join(caKeys, ", "))
+}
+
+func (c *Client) getSecret(ctx context.Context, ref SecretRef) (*corev1.Secret, error) {
+	klog.V(2).Infof("reading secret %s", ref)
+	secret, err := c.Clientset.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret %s - %s", ref, err.Error())
+	}
+	return secret, nil
+}
+
+// AllocatorAddress returns the external host:port of the allocator service from its load balancer status
+func (c *Client) AllocatorAddress(ctx context.Context, namespace, name string) (string, error) {
+	service, err := c.Clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not read service %s/%s - %s", namespace, name, err.Error())
+	}
+	return serviceAddress(service)
+}
+
+func serviceAddress(service *corev1.Service) (string, error) {
+	if len(service.Status.LoadBalancer.Ingress) < 1 {
+		return "", fmt.Errorf("service %s/%s has no external address yet", service.Namespace, service.Name)
+	}
+	ingress := service.Status.LoadBalancer.Ingress[0]
+	host := ingress.IP
+	if host == "" {
+		host = ingress.Hostname
+	}
+
+	port := int32(443)
+	if len(service.Spec.Ports) > 0 {
+		port = service.Spec.Ports[0].Port
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	klog.V(2).Infof("discovered allocator %s/%s at %s", service.Namespace, service.Name, address)
+	return address, nil
+}
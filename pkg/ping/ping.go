@@ -26,11 +26,14 @@ type Trace struct {
 	RoundTripTime time.Duration `json:"roundTripTime,omitempty"`
 }
 
-// RoundTrip wraps http.DefaultTransport.RoundTrip to keep track
-// of the current request.
+// RoundTrip sends the request on a new connection, and keeps track of the current request.
+// The response time is measured from the start of the connection, so pinging the same
+// host again must not reuse a kept-alive one.
 func (t *Trace) RoundTrip(req *http.Request) (*http.Response, error) {
 	t.request = req
-	return http.DefaultTransport.RoundTrip(req)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	return transport.RoundTrip(req)
 }
 
 // GotConn prints whether the connection has been used previously
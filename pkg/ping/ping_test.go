@@ -1,6 +1,7 @@
 package ping
 
 import (
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -96,3 +97,16 @@ func TestTrace_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestTrace_Run_again(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	// Every ping opens its own connection, so the second one is timed like the first
+	for i := 0; i < 2; i++ {
+		trace := Trace{Host: server.URL}
+		assert.NoError(t, trace.Run())
+		assert.Greater(t, int64(trace.ResponseTime), int64(0))
+		assert.Less(t, int64(trace.ResponseTime), int64(time.Second))
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package ping
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// HTTPResponse is the body the Agones HTTP ping service answers with
+const HTTPResponse = "ok"
+
+// Faults are the network conditions a ping server simulates
+type Faults struct {
+	// Latency is added before every reply
+	Latency time.Duration
+	// Jitter adds up to this much more latency, picked at random for each reply
+	Jitter time.Duration
+	// Loss is the chance, from 0 to 1, that a ping is never answered. Lost HTTP pings have
+	// their connection closed without a response.
+	Loss float64
+}
+
+// Server answers pings the same way as the Agones ping service, over HTTP and UDP,
+// with optional latency and packet loss
+type Server struct {
+	faults Faults
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewServer returns a ping server that simulates the faults
+func NewServer(faults Faults) *Server {
+	return &Server{
+		faults: faults,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Handler answers HTTP pings the same way as the Agones ping service, without any faults
+func Handler() http.Handler {
+	return NewServer(Faults{})
+}
+
+// delay returns how long to wait before answering a ping, or false if the ping is lost
+func (s *Server) delay() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.faults.Loss > 0 && s.random.Float64() < s.faults.Loss {
+		return 0, false
+	}
+	delay := s.faults.Latency
+	if s.faults.Jitter > 0 {
+		delay += time.Duration(s.random.Int63n(int64(s.faults.Jitter)))
+	}
+	return delay, true
+}
+
+// ServeHTTP answers an HTTP ping
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	delay, ok := s.delay()
+	if !ok {
+		klog.V(4).Infof("dropping http ping from %s", r.RemoteAddr)
+		if hijacker, isHijacker := w.(http.Hijacker); isHijacker {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(delay):
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(HTTPResponse))
+}
+
+// ServeUDP echoes every packet back to its sender until the context is cancelled
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buffer := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		delay, ok := s.delay()
+		if !ok {
+			klog.V(4).Infof("dropping udp ping from %s", addr)
+			continue
+		}
+		reply := append([]byte{}, buffer[:n]...)
+		time.AfterFunc(delay, func() {
+			_, _ = conn.WriteTo(reply, addr)
+		})
+	}
+}
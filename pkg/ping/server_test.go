@@ -0,0 +1,106 @@
+/*
+Copyright 2020 Fairwinds
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License
+*/
+
+package ping
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	trace := Trace{Host: server.URL}
+	assert.NoError(t, trace.Run())
+	assert.Equal(t, HTTPResponse, trace.Response)
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		faults      Faults
+		wantErr     bool
+		wantAtLeast time.Duration
+	}{
+		{name: "no faults"},
+		{name: "latency", faults: Faults{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, wantAtLeast: 50 * time.Millisecond},
+		{name: "loss", faults: Faults{Loss: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(NewServer(tt.faults))
+			defer server.Close()
+
+			trace := Trace{Host: server.URL}
+			err := trace.Run()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, HTTPResponse, trace.Response)
+			assert.GreaterOrEqual(t, int64(trace.ResponseTime), int64(tt.wantAtLeast))
+		})
+	}
+}
+
+func TestServer_ServeUDP(t *testing.T) {
+	tests := []struct {
+		name    string
+		faults  Faults
+		wantErr bool
+	}{
+		{name: "echo", faults: Faults{Latency: 10 * time.Millisecond}},
+		{name: "loss", faults: Faults{Loss: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- NewServer(tt.faults).ServeUDP(ctx, conn) }()
+			defer func() {
+				cancel()
+				assert.NoError(t, <-done)
+			}()
+
+			client, err := net.Dial("udp", conn.LocalAddr().String())
+			require.NoError(t, err)
+			defer client.Close()
+			_, err = client.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			require.NoError(t, client.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+			reply := make([]byte, 16)
+			n, err := client.Read(reply)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(reply[:n]))
+		})
+	}
+}